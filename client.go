@@ -104,6 +104,22 @@ func (c *Client) Download(clusterName, fid string) ([]byte, error) {
 	return cluster.Download(fid)
 }
 
+// DownloadWithProgress download file in the cluster and report progress to p.
+//
+// DownloadWithProgress is a wrapper of DefaultClient.DownloadWithProgress.
+func DownloadWithProgress(clusterName, fid string, p *cluster.Progress) ([]byte, error) {
+	return DefaultClient.DownloadWithProgress(clusterName, fid, p)
+}
+
+// DownloadWithProgress download file in the cluster and report progress to p.
+func (c *Client) DownloadWithProgress(clusterName, fid string, p *cluster.Progress) ([]byte, error) {
+	cluster, ok := c.Cluster(clusterName)
+	if !ok {
+		return nil, unknownClusterErr(clusterName)
+	}
+	return cluster.DownloadWithProgress(fid, p)
+}
+
 // UpdateStorageGroup update cluster storage pool config belong to same group.
 //
 // UpdateStorageGroup is a wrapper of DefaultClient.UpdateStorageGroup.
@@ -189,3 +205,21 @@ func (c *Client) UploadSlave(clusterName, master, suffix, ext string, b []byte)
 	}
 	return cluster.UploadSlave(b, master, suffix, ext)
 }
+
+// UploadWithProgress upload file to the cluster group with specified return filename extension
+// and report progress to p. The uploaded file cannot be appended.
+//
+// UploadWithProgress is a wrapper of DefaultClient.UploadWithProgress.
+func UploadWithProgress(clusterName, group, ext string, b []byte, p *cluster.Progress) (string, error) {
+	return DefaultClient.UploadWithProgress(clusterName, group, ext, b, p)
+}
+
+// UploadWithProgress upload file to the cluster group with specified return filename extension
+// and report progress to p. The uploaded file cannot be appended.
+func (c *Client) UploadWithProgress(clusterName, group, ext string, b []byte, p *cluster.Progress) (string, error) {
+	cluster, ok := c.Cluster(clusterName)
+	if !ok {
+		return "", unknownClusterErr(clusterName)
+	}
+	return cluster.UploadWithProgress(b, group, ext, p)
+}
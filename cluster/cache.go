@@ -0,0 +1,305 @@
+package cluster
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultCacheDiskSize is max bytes of disk cache if disk size is not set
+	defaultCacheDiskSize = 1024 * 1024 * 1024
+	// generationSlots is number of slots fids are hashed to for generations
+	generationSlots = 64
+)
+
+// CacheConfig defines the download cache of a cluster.
+type CacheConfig struct {
+	// MemorySize is max bytes of file content cached in memory. 0 means no memory cache.
+	MemorySize int64
+
+	// DiskDir is directory of the disk cache. Empty means no disk cache.
+	// Cached files under it are kept and reused after restart.
+	DiskDir string
+
+	// DiskSize is max bytes of files under DiskDir. Default 1G.
+	DiskSize int64
+}
+
+// lruEntry is a cached item. b is nil for disk cache entries.
+type lruEntry struct {
+	key  string
+	b    []byte
+	size int64
+}
+
+// lru is a byte bounded least recently used list. It is not safe for concurrent use.
+type lru struct {
+	capacity int64
+	used     int64
+
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newLRU(capacity int64) *lru {
+	return &lru{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get return the entry of key and mark it recently used
+func (l *lru) get(key string) (*lruEntry, bool) {
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(e)
+	return e.Value.(*lruEntry), true
+}
+
+// add put the entry as most recently used and return the entries evicted to make room for it.
+// An entry larger than capacity is not added.
+func (l *lru) add(entry *lruEntry) []*lruEntry {
+	if entry.size > l.capacity {
+		return nil
+	}
+	l.remove(entry.key)
+	var evicted []*lruEntry
+	for l.used+entry.size > l.capacity {
+		oldest := l.ll.Back().Value.(*lruEntry)
+		l.remove(oldest.key)
+		evicted = append(evicted, oldest)
+	}
+	l.items[entry.key] = l.ll.PushFront(entry)
+	l.used += entry.size
+	return evicted
+}
+
+// remove the entry of key, return false if not exist
+func (l *lru) remove(key string) bool {
+	e, ok := l.items[key]
+	if !ok {
+		return false
+	}
+	l.ll.Remove(e)
+	delete(l.items, key)
+	l.used -= e.Value.(*lruEntry).size
+	return true
+}
+
+// diskCache stores each file under a directory, named by hash of its fid.
+type diskCache struct {
+	dir   string
+	index *lru
+
+	mtx sync.Mutex
+}
+
+// openDiskCache create the directory if not exist and load files already in it,
+// least recently modified ones are evicted first.
+func openDiskCache(dir string, size int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	dc := &diskCache{dir: dir, index: newLRU(size)}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		//temp file left by an interrupted write
+		if strings.Contains(info.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, info.Name()))
+			continue
+		}
+		dc.evict(dc.index.add(&lruEntry{key: info.Name(), size: info.Size()}))
+	}
+	return dc, nil
+}
+
+func (dc *diskCache) get(fid string) ([]byte, bool) {
+	key := dc.key(fid)
+	dc.mtx.Lock()
+	_, ok := dc.index.get(key)
+	dc.mtx.Unlock()
+	if !ok {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dc.dir, key))
+	if err != nil {
+		dc.mtx.Lock()
+		dc.index.remove(key)
+		dc.mtx.Unlock()
+		return nil, false
+	}
+	return b, true
+}
+
+func (dc *diskCache) add(fid string, b []byte) {
+	if int64(len(b)) > dc.index.capacity {
+		return
+	}
+	key := dc.key(fid)
+	if err := writeFileAtomic(filepath.Join(dc.dir, key), b); err != nil {
+		return
+	}
+	dc.mtx.Lock()
+	defer dc.mtx.Unlock()
+
+	dc.evict(dc.index.add(&lruEntry{key: key, size: int64(len(b))}))
+}
+
+func (dc *diskCache) remove(fid string) {
+	key := dc.key(fid)
+	dc.mtx.Lock()
+	defer dc.mtx.Unlock()
+
+	dc.index.remove(key)
+	os.Remove(filepath.Join(dc.dir, key))
+}
+
+// evict remove files of evicted entries
+func (dc *diskCache) evict(entries []*lruEntry) {
+	for _, e := range entries {
+		os.Remove(filepath.Join(dc.dir, e.key))
+	}
+}
+
+// key return cache file name. Fid is hashed because it contains path separators.
+func (dc *diskCache) key(fid string) string {
+	sum := sha1.Sum([]byte(fid))
+	return hex.EncodeToString(sum[:])
+}
+
+// fileCache caches whole file content by fid in memory and optionally on disk.
+// Memory cache is checked first, disk cache hits are moved into memory.
+// Content is always copied in and out, so callers may modify what they get.
+type fileCache struct {
+	memory *lru
+	disk   *diskCache
+
+	// generations of fids hashed to slots. remove bumps it, so content downloaded before is not added.
+	generations [generationSlots]uint64
+
+	mtx sync.Mutex
+}
+
+func newFileCache(config CacheConfig) (*fileCache, error) {
+	fc := &fileCache{memory: newLRU(config.MemorySize)}
+	if config.DiskDir != "" {
+		size := config.DiskSize
+		if size <= 0 {
+			size = defaultCacheDiskSize
+		}
+		dc, err := openDiskCache(config.DiskDir, size)
+		if err != nil {
+			return nil, err
+		}
+		fc.disk = dc
+	}
+	return fc, nil
+}
+
+// get return length bytes from offset of cached file, length 0 means to the end.
+func (fc *fileCache) get(fid string, offset, length int64) ([]byte, bool) {
+	fc.mtx.Lock()
+	e, ok := fc.memory.get(fid)
+	gen := fc.generations[generationSlot(fid)]
+	fc.mtx.Unlock()
+
+	var b []byte
+	if ok {
+		b = e.b
+	} else if fc.disk != nil {
+		if b, ok = fc.disk.get(fid); ok {
+			fc.addMemory(fid, b, gen)
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	size := int64(len(b))
+	if length == 0 {
+		length = size - offset
+	}
+	if offset < 0 || length < 0 || offset+length > size {
+		return nil, false
+	}
+	return append([]byte(nil), b[offset:offset+length]...), true
+}
+
+// generation return the generation of fid, get it before downloading the content to add.
+func (fc *fileCache) generation(fid string) uint64 {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+
+	return fc.generations[generationSlot(fid)]
+}
+
+// add whole file content of fid to cache, unless fid is removed since generation gen.
+func (fc *fileCache) add(fid string, b []byte, gen uint64) {
+	fc.addMemory(fid, b, gen)
+	if fc.disk == nil || fc.generation(fid) != gen {
+		return
+	}
+	fc.disk.add(fid, b)
+	//a remove during the write may have missed the file
+	if fc.generation(fid) != gen {
+		fc.disk.remove(fid)
+	}
+}
+
+func (fc *fileCache) addMemory(fid string, b []byte, gen uint64) {
+	if int64(len(b)) > fc.memory.capacity {
+		return
+	}
+	entry := &lruEntry{key: fid, b: append([]byte(nil), b...), size: int64(len(b))}
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+
+	if fc.generations[generationSlot(fid)] == gen {
+		fc.memory.add(entry)
+	}
+}
+
+// remove cached content of fid.
+func (fc *fileCache) remove(fid string) {
+	fc.mtx.Lock()
+	fc.generations[generationSlot(fid)]++
+	fc.memory.remove(fid)
+	fc.mtx.Unlock()
+
+	if fc.disk != nil {
+		fc.disk.remove(fid)
+	}
+}
+
+func generationSlot(fid string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(fid))
+	return h.Sum32() % generationSlots
+}
+
+// cacheable return true if content of fid never changes. Appender files can be appended,
+// modified or truncated, and a fid cannot be decoded is not known to be immutable.
+func cacheable(fid string) bool {
+	id, err := DecodeFileID(fid)
+	return err == nil && !id.Appender
+}
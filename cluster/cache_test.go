@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLRUEvict(t *testing.T) {
+	l := newLRU(10)
+	l.add(&lruEntry{key: "a", size: 4})
+	l.add(&lruEntry{key: "b", size: 4})
+	l.get("a")
+	evicted := l.add(&lruEntry{key: "c", size: 4})
+	if len(evicted) != 1 || evicted[0].key != "b" {
+		t.Errorf("evicted %v, want b", evicted)
+	}
+	if evicted := l.add(&lruEntry{key: "d", size: 11}); evicted != nil {
+		t.Errorf("entry larger than capacity evicted %v", evicted)
+	}
+	if _, ok := l.get("d"); ok {
+		t.Error("entry larger than capacity should not be added")
+	}
+	if l.used != 8 {
+		t.Errorf("used %d, want 8", l.used)
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fc, err := newFileCache(CacheConfig{MemorySize: 8, DiskDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.add("g1/a", []byte("0123456789"), fc.generation("g1/a"))
+	if b, ok := fc.get("g1/a", 2, 3); !ok || string(b) != "234" {
+		t.Errorf("get range return %q %v, want 234", b, ok)
+	}
+	if _, ok := fc.get("g1/a", 8, 3); ok {
+		t.Error("range out of file should miss")
+	}
+
+	//files on disk are loaded by a new cache
+	fc, err = newFileCache(CacheConfig{DiskDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := fc.get("g1/a", 0, 0); !ok || string(b) != "0123456789" {
+		t.Errorf("get from disk return %q %v", b, ok)
+	}
+	fc.remove("g1/a")
+	if _, ok := fc.get("g1/a", 0, 0); ok {
+		t.Error("removed file should miss")
+	}
+
+	//content downloaded before the file is removed is not cached
+	gen := fc.generation("g1/b")
+	fc.remove("g1/b")
+	fc.add("g1/b", []byte("stale"), gen)
+	if _, ok := fc.get("g1/b", 0, 0); ok {
+		t.Error("content added after remove should miss")
+	}
+	fc.add("g1/b", []byte("fresh"), fc.generation("g1/b"))
+	if b, ok := fc.get("g1/b", 0, 0); !ok || string(b) != "fresh" {
+		t.Errorf("get after remove return %q %v", b, ok)
+	}
+}
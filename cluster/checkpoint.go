@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoint records progress of a resumable or chunked upload.
+type Checkpoint struct {
+	// Fid is the appender file created by the first chunk
+	Fid string `json:"fid"`
+
+	// Offset is bytes confirmed appended to the file
+	Offset int64 `json:"offset"`
+
+	// Size is total bytes of the source
+	Size int64 `json:"size"`
+
+	// Chunks are fids of chunks of a chunked upload, empty for chunks not uploaded yet
+	Chunks []string `json:"chunks,omitempty"`
+
+	// ChunkSize is chunk size of a chunked upload
+	ChunkSize int64 `json:"chunk_size,omitempty"`
+}
+
+// CheckpointStore persists checkpoints by user defined key.
+type CheckpointStore interface {
+	// Load return the checkpoint of key. It returns nil checkpoint and nil error if not exist.
+	Load(key string) (*Checkpoint, error)
+
+	// Save checkpoint of key, overwrite the old one.
+	Save(key string, cp *Checkpoint) error
+
+	// Remove checkpoint of key. Remove a not exist key is not an error.
+	Remove(key string) error
+}
+
+// FileCheckpointStore stores each checkpoint as a json file under a directory.
+type FileCheckpointStore struct {
+	dir string
+
+	mtx sync.Mutex
+}
+
+// NewFileCheckpointStore create a checkpoint store saving files to dir.
+// The directory will be created if not exist.
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+// Load checkpoint of key from its file.
+func (fs *FileCheckpointStore) Load(key string) (*Checkpoint, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	b, err := ioutil.ReadFile(fs.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Save checkpoint of key. The file is replaced atomically, so a crash never leaves a half written checkpoint.
+func (fs *FileCheckpointStore) Save(key string, cp *Checkpoint) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.path(key), b)
+}
+
+// Remove checkpoint file of key.
+func (fs *FileCheckpointStore) Remove(key string) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if err := os.Remove(fs.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path return checkpoint file path. Key is hashed because it may contain path separators.
+func (fs *FileCheckpointStore) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(fs.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// writeFileAtomic write b to a temp file in the same directory and rename it to path.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileCheckpointStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "/data/backup/2018.tar"
+	cp, err := store.Load(key)
+	if err != nil || cp != nil {
+		t.Fatalf("load not exist key, got %v, %v", cp, err)
+	}
+
+	want := Checkpoint{Fid: "g1/M00/00/00/CgIG6VuXIoeAbiwbAAAIIRe5FG4412.tar", Offset: 4096, Size: 10240}
+	if err := store.Save(key, &want); err != nil {
+		t.Fatal(err)
+	}
+	cp, err = store.Load(key)
+	if err != nil || cp == nil || !reflect.DeepEqual(*cp, want) {
+		t.Fatalf("load saved key, got %v, %v", cp, err)
+	}
+
+	if err := store.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove(key); err != nil {
+		t.Fatalf("remove not exist key: %v", err)
+	}
+	cp, err = store.Load(key)
+	if err != nil || cp != nil {
+		t.Fatalf("load removed key, got %v, %v", cp, err)
+	}
+}
+
+func TestChunkedResumeChunkSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileCheckpointStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "/data/backup/2018.tar"
+	if err := store.Save(key, &Checkpoint{Size: 100, Chunks: make([]string, 2), ChunkSize: 50}); err != nil {
+		t.Fatal(err)
+	}
+	u := New("test").NewChunkedUploader(store, ChunkConfig{ChunkSize: 60, Groups: []string{"g1"}})
+	if _, err := u.Upload(key, bytes.NewReader(make([]byte, 100)), 100, "tar"); err == nil {
+		t.Error("resume with a different chunk size should fail")
+	}
+}
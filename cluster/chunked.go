@@ -0,0 +1,357 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// defaultObjectChunkSize is chunk size of chunked objects if not set, below default download size limit
+	defaultObjectChunkSize = 32 * 1024 * 1024
+	// defaultChunkParallelism is number of chunks uploaded at the same time if not set
+	defaultChunkParallelism = 4
+)
+
+// ChunkManifest records chunks of a large object. It is saved as a file.
+type ChunkManifest struct {
+	// Size is object size in bytes
+	Size int64 `json:"size"`
+
+	// ChunkSize is size of all chunks but the last one
+	ChunkSize int64 `json:"chunk_size"`
+
+	// Chunks are fids of chunks in order
+	Chunks []string `json:"chunks"`
+}
+
+// ChunkConfig defines how a large object is split and uploaded.
+type ChunkConfig struct {
+	// ChunkSize is bytes of each chunk. Default 32M. Keep it below DownloadSizeLimit of storage.
+	ChunkSize int64
+
+	// Groups chunks are uploaded to in turn. The manifest is uploaded to the first group.
+	Groups []string
+
+	// Parallelism is number of chunks uploaded at the same time. Default 4.
+	Parallelism int
+}
+
+// ChunkedUploader uploads objects larger than a single file should be as chunks with a manifest.
+//
+// Chunks are uploaded in parallel and each uploaded chunk is saved to the checkpoint store.
+// If the upload is interrupted, call Upload with the same key again and only chunks not saved
+// are uploaded. A chunk uploaded just before interruption but not saved is left orphan.
+type ChunkedUploader struct {
+	cluster *Cluster
+
+	store  CheckpointStore
+	config ChunkConfig
+
+	// uploadFile upload a chunk to group, replaced in tests
+	uploadFile func(b []byte, group, ext string) (string, *Error)
+}
+
+// NewChunkedUploader create a chunked uploader of DefaultCluster.
+//
+// NewChunkedUploader is a wrapper of DefaultCluster.NewChunkedUploader.
+func NewChunkedUploader(store CheckpointStore, config ChunkConfig) *ChunkedUploader {
+	return DefaultCluster.NewChunkedUploader(store, config)
+}
+
+// NewChunkedUploader create a chunked uploader saving progress to store.
+func (c *Cluster) NewChunkedUploader(store CheckpointStore, config ChunkConfig) *ChunkedUploader {
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = defaultObjectChunkSize
+	}
+	if config.Parallelism <= 0 {
+		config.Parallelism = defaultChunkParallelism
+	}
+	return &ChunkedUploader{
+		cluster: c,
+		store:   store,
+		config:  config,
+		uploadFile: func(b []byte, group, ext string) (string, *Error) {
+			return c.upload(b, group, ext, false, nil)
+		},
+	}
+}
+
+// Upload size bytes of r as chunks with specified extension name and return fid of the manifest.
+// Key identifies the upload in checkpoint store, it must be the same when resuming, and so must ChunkSize.
+// Checkpoint is removed after the manifest is uploaded.
+func (u *ChunkedUploader) Upload(key string, r io.ReaderAt, size int64, ext string) (string, error) {
+	fid, err := u.upload(key, r, size, ext)
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
+}
+
+func (u *ChunkedUploader) upload(key string, r io.ReaderAt, size int64, ext string) (string, *Error) {
+	c := u.cluster
+	if len(u.config.Groups) == 0 {
+		return "", c.wrapError(chunkErr(errors.New("no group to upload to")))
+	}
+	count := int((size + u.config.ChunkSize - 1) / u.config.ChunkSize)
+
+	cp, e := u.store.Load(key)
+	if e != nil {
+		return "", c.wrapError(checkpointErr(e))
+	}
+	if cp == nil {
+		cp = &Checkpoint{Size: size, Chunks: make([]string, count), ChunkSize: u.config.ChunkSize}
+	} else if cp.Size != size || len(cp.Chunks) != count || cp.ChunkSize != u.config.ChunkSize {
+		//chunks of another size would not match the manifest
+		return "", c.wrapError(checkpointErr(fmt.Errorf("checkpoint of %d bytes in %d chunks of %d bytes, source %d bytes in %d chunks of %d bytes",
+			cp.Size, len(cp.Chunks), cp.ChunkSize, size, count, u.config.ChunkSize)))
+	}
+
+	if err := u.uploadChunks(key, cp, r, ext); err != nil {
+		return "", err
+	}
+
+	m := &ChunkManifest{Size: size, ChunkSize: u.config.ChunkSize, Chunks: cp.Chunks}
+	fid, err := c.uploadManifest(m, u.config.Groups[0])
+	if err != nil {
+		return "", err
+	}
+	if e := u.store.Remove(key); e != nil {
+		return "", c.wrapError(checkpointErr(e))
+	}
+	return fid, nil
+}
+
+// uploadChunks upload chunks not in checkpoint by parallel workers. Workers stop taking
+// chunks after the first error.
+func (u *ChunkedUploader) uploadChunks(key string, cp *Checkpoint, r io.ReaderAt, ext string) *Error {
+	c := u.cluster
+	todo := make(chan int)
+	var firstErr *Error
+	var mtx sync.Mutex
+	failed := func(err *Error) bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return firstErr != nil
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < u.config.Parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, u.config.ChunkSize)
+			for i := range todo {
+				if failed(nil) {
+					continue
+				}
+				failed(u.uploadChunk(key, cp, r, buf, i, ext, &mtx))
+			}
+		}()
+	}
+	for i, fid := range cp.Chunks {
+		if fid == "" {
+			todo <- i
+		}
+	}
+	close(todo)
+	wg.Wait()
+
+	if firstErr != nil {
+		return c.wrapError(firstErr)
+	}
+	return nil
+}
+
+// uploadChunk read and upload chunk i, then save it to checkpoint
+func (u *ChunkedUploader) uploadChunk(key string, cp *Checkpoint, r io.ReaderAt, buf []byte, i int, ext string, mtx *sync.Mutex) *Error {
+	offset := int64(i) * u.config.ChunkSize
+	n := cp.Size - offset
+	if n > u.config.ChunkSize {
+		n = u.config.ChunkSize
+	}
+	if m, e := r.ReadAt(buf[:n], offset); int64(m) < n {
+		return readSourceErr(e)
+	}
+	group := u.config.Groups[i%len(u.config.Groups)]
+	fid, err := u.uploadFile(buf[:n], group, ext)
+	if err != nil {
+		return err
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	cp.Chunks[i] = fid
+	if e := u.store.Save(key, cp); e != nil {
+		return checkpointErr(e)
+	}
+	return nil
+}
+
+// ChunkedObject reads a chunked object. It implements io.Reader, io.ReaderAt, io.Seeker and io.WriterTo.
+// ReadAt is safe for concurrent use, the others are not.
+type ChunkedObject struct {
+	cluster  *Cluster
+	manifest *ChunkManifest
+
+	// download a range of a chunk, replaced in tests
+	download func(fid string, offset, length int64) ([]byte, *Error)
+
+	// offset of Read and WriteTo
+	offset int64
+}
+
+// OpenChunked download the manifest and return the chunked object to read.
+//
+// OpenChunked is a wrapper of DefaultCluster.OpenChunked.
+func OpenChunked(fid string) (*ChunkedObject, error) {
+	return DefaultCluster.OpenChunked(fid)
+}
+
+// OpenChunked download the manifest and return the chunked object to read.
+func (c *Cluster) OpenChunked(fid string) (*ChunkedObject, error) {
+	m := &ChunkManifest{}
+	if err := c.downloadManifest(fid, m); err != nil {
+		return nil, err
+	}
+	if m.ChunkSize <= 0 || int64(len(m.Chunks)) != (m.Size+m.ChunkSize-1)/m.ChunkSize {
+		return nil, c.wrapError(manifestErr(fmt.Errorf("%d chunks of %d bytes for %d bytes object", len(m.Chunks), m.ChunkSize, m.Size)))
+	}
+	return &ChunkedObject{cluster: c, manifest: m, download: c.DownloadFromOffset}, nil
+}
+
+// Size return object size in bytes.
+func (o *ChunkedObject) Size() int64 {
+	return o.manifest.Size
+}
+
+// ReadAt read len(p) bytes from off. Only the needed range of each chunk is downloaded.
+func (o *ChunkedObject) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, o.cluster.wrapError(chunkErr(errors.New("negative offset")))
+	}
+	n := 0
+	for n < len(p) && off < o.manifest.Size {
+		i := off / o.manifest.ChunkSize
+		within := off % o.manifest.ChunkSize
+		length := o.manifest.ChunkSize - within
+		if length > int64(len(p)-n) {
+			length = int64(len(p) - n)
+		}
+		if length > o.manifest.Size-off {
+			length = o.manifest.Size - off
+		}
+		b, err := o.download(o.manifest.Chunks[i], within, length)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], b)
+		off += int64(len(b))
+		if int64(len(b)) < length {
+			return n, o.cluster.wrapError(chunkErr(fmt.Errorf("chunk %d is shorter than manifest", i)))
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read the next len(p) bytes.
+func (o *ChunkedObject) Read(p []byte) (int, error) {
+	if o.offset >= o.manifest.Size {
+		return 0, io.EOF
+	}
+	n, err := o.ReadAt(p, o.offset)
+	o.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek set offset of the next Read.
+func (o *ChunkedObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.manifest.Size
+	default:
+		return o.offset, o.cluster.wrapError(chunkErr(fmt.Errorf("invalid whence %d", whence)))
+	}
+	if offset < 0 {
+		return o.offset, o.cluster.wrapError(chunkErr(errors.New("negative offset")))
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+// WriteTo stream the rest of the object to w chunk by chunk, so io.Copy does not download
+// in small pieces.
+func (o *ChunkedObject) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for o.offset < o.manifest.Size {
+		i := o.offset / o.manifest.ChunkSize
+		within := o.offset % o.manifest.ChunkSize
+		b, err := o.download(o.manifest.Chunks[i], within, 0)
+		if err != nil {
+			return written, err
+		}
+		//the rest of the chunk must be as long as the manifest says
+		if expect := o.chunkSize(i) - within; int64(len(b)) != expect {
+			return written, o.cluster.wrapError(chunkErr(fmt.Errorf("chunk %d has %d bytes from offset %d, manifest %d", i, len(b), within, expect)))
+		}
+		n, e := w.Write(b)
+		written += int64(n)
+		o.offset += int64(n)
+		if e != nil {
+			return written, e
+		}
+	}
+	return written, nil
+}
+
+// chunkSize return size of chunk i in manifest, the last chunk may be shorter
+func (o *ChunkedObject) chunkSize(i int64) int64 {
+	if rest := o.manifest.Size - i*o.manifest.ChunkSize; rest < o.manifest.ChunkSize {
+		return rest
+	}
+	return o.manifest.ChunkSize
+}
+
+// DeleteChunked delete all chunks and the manifest of a chunked object.
+//
+// DeleteChunked is a wrapper of DefaultCluster.DeleteChunked.
+func DeleteChunked(fid string) error {
+	return DefaultCluster.DeleteChunked(fid)
+}
+
+// DeleteChunked delete all chunks and the manifest of a chunked object. A chunk already gone is
+// not an error. If any chunk fails, the manifest is kept so it can be called again.
+func (c *Cluster) DeleteChunked(fid string) error {
+	m := &ChunkManifest{}
+	if err := c.downloadManifest(fid, m); err != nil {
+		return err
+	}
+	var first *Error
+	for _, chunk := range m.Chunks {
+		if err := c.Delete(chunk); err != nil && !isFileNotExist(err) && first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return first
+	}
+	if err := c.Delete(fid); err != nil {
+		return err
+	}
+	return nil
+}
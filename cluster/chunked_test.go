@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// chunkStore fakes chunk files, fid is the chunk content
+type chunkStore struct {
+	mtx      sync.Mutex
+	uploads  []string
+	requests []string
+}
+
+func (cs *chunkStore) upload(b []byte, group, ext string) (string, *Error) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	cs.uploads = append(cs.uploads, string(b))
+	return string(b), nil
+}
+
+func (cs *chunkStore) download(fid string, offset, length int64) ([]byte, *Error) {
+	cs.requests = append(cs.requests, fmt.Sprintf("%s:%d:%d", fid, offset, length))
+	end := int64(len(fid))
+	if length > 0 && offset+length < end {
+		end = offset + length
+	}
+	return []byte(fid[offset:end]), nil
+}
+
+func newTestChunkedObject(chunks ...string) (*ChunkedObject, *chunkStore) {
+	cs := &chunkStore{}
+	m := &ChunkManifest{Size: int64(len(strings.Join(chunks, ""))), ChunkSize: int64(len(chunks[0])), Chunks: chunks}
+	return &ChunkedObject{cluster: New("test"), manifest: m, download: cs.download}, cs
+}
+
+func TestChunkedObjectReadAt(t *testing.T) {
+	o, cs := newTestChunkedObject("0123456789", "abcdefghij", "ABCDE")
+
+	cases := []struct {
+		off      int64
+		size     int
+		data     string
+		eof      bool
+		requests []string
+	}{
+		{0, 10, "0123456789", false, []string{"0123456789:0:10"}},
+		{8, 4, "89ab", false, []string{"0123456789:8:2", "abcdefghij:0:2"}},
+		{9, 12, "9abcdefghijA", false, []string{"0123456789:9:1", "abcdefghij:0:10", "ABCDE:0:1"}},
+		{20, 10, "ABCDE", true, []string{"ABCDE:0:5"}},
+		{25, 1, "", true, nil},
+	}
+	for i, c := range cases {
+		cs.requests = nil
+		p := make([]byte, c.size)
+		n, err := o.ReadAt(p, c.off)
+		if string(p[:n]) != c.data || (err == io.EOF) != c.eof || (err != nil && err != io.EOF) {
+			t.Errorf("case %d: read %q, %v", i, p[:n], err)
+		}
+		if fmt.Sprint(cs.requests) != fmt.Sprint(c.requests) {
+			t.Errorf("case %d: requests %v", i, cs.requests)
+		}
+	}
+
+	//a chunk shorter than manifest
+	o, _ = newTestChunkedObject("0123456789", "abc", "ABCDE")
+	o.manifest.Size = 25
+	if n, err := o.ReadAt(make([]byte, 10), 8); n != 5 || err == nil || err == io.EOF {
+		t.Errorf("read short chunk %d, %v", n, err)
+	}
+}
+
+func TestChunkedObjectWriteTo(t *testing.T) {
+	o, _ := newTestChunkedObject("0123456789", "abcdefghij", "ABCDE")
+	o.Seek(5, io.SeekStart)
+	var buf bytes.Buffer
+	if n, err := o.WriteTo(&buf); n != 20 || err != nil || buf.String() != "56789abcdefghijABCDE" {
+		t.Errorf("write %d %q, %v", n, buf.String(), err)
+	}
+
+	//chunks not matching manifest lengths
+	for _, chunks := range [][]string{
+		{"0123456789", "abc", "ABCDE"},
+		{"0123456789", "abcdefghijklm", "ABCDE"},
+		{"0123456789", "abcdefghij", "ABCDEFG"},
+	} {
+		o, _ := newTestChunkedObject(chunks...)
+		o.manifest.Size = 25
+		if _, err := o.WriteTo(ioutil.Discard); err == nil {
+			t.Errorf("write chunks %v of manifest", chunks)
+		}
+	}
+}
+
+func TestChunkedUploaderResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileCheckpointStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("0123456789abcdefghijABCDEFGHIJklmnopqrstKLMNO")
+	key := "/data/backup/2018.tar"
+	cp := &Checkpoint{Size: int64(len(content)), Chunks: make([]string, 5), ChunkSize: 10}
+	cp.Chunks[0], cp.Chunks[3] = "0123456789", "klmnopqrst"
+	if err := store.Save(key, cp); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := &chunkStore{}
+	u := New("test").NewChunkedUploader(store, ChunkConfig{ChunkSize: 10, Groups: []string{"g1", "g2"}, Parallelism: 3})
+	u.uploadFile = cs.upload
+	cp, err = store.Load(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.uploadChunks(key, cp, bytes.NewReader(content), "tar"); err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.uploads) != 3 {
+		t.Errorf("uploaded %v", cs.uploads)
+	}
+	saved, err := store.Load(key)
+	if err != nil || strings.Join(saved.Chunks, "") != string(content) {
+		t.Errorf("saved %v, %v", saved, err)
+	}
+}
@@ -1,9 +1,14 @@
 package cluster
 
 import (
+	"context"
+	"io"
 	"math/rand"
+	"net"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,14 +30,62 @@ type Cluster struct {
 	// tracker peers belong to the cluster
 	trackerPeers []*Tracker
 
+	// bandwidth limiters shared by all storage of the cluster
+	uploadLimiter   *rateLimiter
+	downloadLimiter *rateLimiter
+
+	// cached tracker query results
+	routes *routeCache
+
+	// coalescing is 1 if concurrent identical downloads share one fetch
+	coalescing int32
+	flights    *flightGroup
+
+	// cache of downloaded files, nil if disabled
+	cache *fileCache
+
+	// hedge policy of downloads, nil if disabled
+	hedge *hedger
+
+	// syncWindow is nanoseconds after upload a file is read from its source storage
+	syncWindow int64
+
+	// dedup index of DedupUpload, nil if not set
+	dedup DedupIndex
+
+	// codec compressing uploads, nil if disabled
+	codec *codec
+
+	// decoding is 1 once compression is enabled, then Download decompresses files
+	decoding int32
+
+	// sweeper deleting expired files, nil if expiry is not set
+	sweeper *sweeper
+
+	// trash of deleted files, nil if disabled
+	trash *trashBin
+
+	// store of UploadIdempotent, nil if not set
+	idempotency IdempotencyStore
+
+	// directory of transaction journals, empty if not set
+	txJournalDir string
+
+	// transactions in progress by id, skipped by RecoverTx
+	openTxs sync.Map
+
 	mtx sync.RWMutex
 }
 
 // New create a cluster with specified name.
 func New(name string) *Cluster {
 	return &Cluster{
-		name:         name,
-		trackerPeers: make([]*Tracker, 0, 1),
+		name:            name,
+		trackerPeers:    make([]*Tracker, 0, 1),
+		uploadLimiter:   newRateLimiter(0),
+		downloadLimiter: newRateLimiter(0),
+		routes:          newRouteCache(),
+		flights:         newFlightGroup(),
 	}
 }
 
@@ -57,6 +110,7 @@ func (c *Cluster) Init(trackerAddress []string, trackerBaseConfig TrackerConfig,
 	}
 	c.trackerBaseConfig = trackerBaseConfig
 	c.storageBaseConfig = storageBaseConfig
+	c.updateClusterConfig(trackerBaseConfig)
 	return nil
 }
 
@@ -97,7 +151,12 @@ func (c *Cluster) Append(b []byte, fid string) error {
 	if err != nil {
 		return err
 	}
-	return s.Append(b, filename)
+	err = s.Append(b, filename)
+	c.invalidateCache(fid)
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
 // Delete the file in this cluster.
@@ -107,8 +166,16 @@ func Delete(fid string) error {
 	return DefaultCluster.Delete(fid)
 }
 
-// Delete the file in this cluster.
+// Delete the file in this cluster. If trash is enabled, the file is moved to trash instead, see SetTrash.
 func (c *Cluster) Delete(fid string) *Error {
+	if tb := c.trashBin(); tb != nil {
+		return c.moveToTrash(tb, fid)
+	}
+	return c.delete(fid)
+}
+
+// delete the file from storage
+func (c *Cluster) delete(fid string) *Error {
 	group, filename, err := c.splitFid(fid)
 	if err != nil {
 		return err
@@ -123,7 +190,10 @@ func (c *Cluster) Delete(fid string) *Error {
 	if err != nil {
 		return err
 	}
-	return s.Delete(filename)
+	c.routes.invalidateDownload(fid)
+	err = s.Delete(filename)
+	c.invalidateCache(fid)
+	return err
 }
 
 // Download the whole file.
@@ -133,35 +203,413 @@ func Download(fid string) ([]byte, error) {
 	return DefaultCluster.Download(fid)
 }
 
-// Download the whole file.
+// Download the whole file. A file compressed by Upload is decompressed.
 func (c *Cluster) Download(fid string) ([]byte, error) {
-	return c.DownloadFromOffset(fid, 0, 0)
+	b, err := c.DownloadFromOffset(fid, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = c.decode(fid, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// DownloadContext download length bytes from offset. It returns when ctx is done.
+//
+// DownloadContext is a wrapper of DefaultCluster.DownloadContext.
+func DownloadContext(ctx context.Context, fid string, offset, length int64) ([]byte, error) {
+	return DefaultCluster.DownloadContext(ctx, fid, offset, length)
+}
+
+// DownloadContext download length bytes from offset. When ctx is done the download is aborted.
+// If coalescing is enabled, it returns at once instead and the download goes on for other callers.
+// Bytes are returned as stored, a compressed file is not decompressed.
+//
+// If coalescing is enabled, the returned bytes may be shared with other callers and must not be modified.
+func (c *Cluster) DownloadContext(ctx context.Context, fid string, offset, length int64) ([]byte, error) {
+	b, err := c.downloadContext(ctx, fid, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
 }
 
 // DownloadFromOffset download length bytes from offset
+//
+// If coalescing is enabled, the returned bytes may be shared with other callers and must not be modified.
 func (c *Cluster) DownloadFromOffset(fid string, offset, length int64) ([]byte, *Error) {
-	//split file id to two parts: group name and file name
-	group, filename, err := c.splitFid(fid)
+	return c.downloadContext(context.Background(), fid, offset, length)
+}
+
+func (c *Cluster) downloadContext(ctx context.Context, fid string, offset, length int64) ([]byte, *Error) {
+	if err := ctx.Err(); err != nil {
+		return nil, c.wrapError(contextErr(err))
+	}
+	//a trashed file may still be cached
+	if err := c.checkTrash(fid); err != nil {
+		return nil, err
+	}
+	if b, ok := c.cachedRange(fid, offset, length); ok {
+		return b, nil
+	}
+	if !c.isCoalescing() {
+		//the download is the caller's own, ctx aborts it
+		return c.downloadAndCache(ctx, fid, offset, length)
+	}
+	key := flightKey{fid: fid, offset: offset, length: length}
+	return c.coalesce(ctx, key, func() ([]byte, *Error) {
+		return c.downloadAndCache(context.Background(), fid, offset, length)
+	})
+}
+
+// coalesce wait for the download of key in flight, or start one by download.
+func (c *Cluster) coalesce(ctx context.Context, key flightKey, download func() ([]byte, *Error)) ([]byte, *Error) {
+	call := c.flights.start(key, true, download)
+	// a cancelled caller returns at once, the download goes on for other callers
+	select {
+	case <-call.done:
+		return call.result()
+	case <-ctx.Done():
+		return nil, c.wrapError(contextErr(ctx.Err()))
+	}
+}
+
+// DownloadWithProgress download the whole file and report progress to p.
+//
+// DownloadWithProgress is a wrapper of DefaultCluster.DownloadWithProgress.
+func DownloadWithProgress(fid string, p *Progress) ([]byte, error) {
+	return DefaultCluster.DownloadWithProgress(fid, p)
+}
+
+// DownloadWithProgress download the whole file and report progress to p.
+// A file compressed by Upload is decompressed, progress is of the stored bytes.
+func (c *Cluster) DownloadWithProgress(fid string, p *Progress) ([]byte, error) {
+	b, err := c.download(context.Background(), fid, 0, 0, p)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = c.decode(fid, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// cachedRange return the range of fid from cache if the whole file is cached
+func (c *Cluster) cachedRange(fid string, offset, length int64) ([]byte, bool) {
+	fc := c.fileCache()
+	if fc == nil {
+		return nil, false
+	}
+	return fc.get(fid, offset, length)
+}
+
+// downloadAndCache download the range and add it to cache if it is a whole immutable file
+func (c *Cluster) downloadAndCache(ctx context.Context, fid string, offset, length int64) ([]byte, *Error) {
+	//an invalidation during the download drops the content
+	fc := c.fileCache()
+	var gen uint64
+	if fc != nil {
+		gen = fc.generation(fid)
+	}
+	var b []byte
+	var err *Error
+	if h := c.hedger(); h != nil {
+		b, err = c.downloadHedged(ctx, h, fid, offset, length)
+	} else {
+		b, err = c.download(ctx, fid, offset, length, nil)
+	}
 	if err != nil {
 		return nil, err
 	}
-	//query a download server from tracker
-	storeInfo, err := c.Tracker().QueryDownloadStorage(group, filename)
+	if fc != nil && offset == 0 && length == 0 && cacheable(fid) {
+		fc.add(fid, b, gen)
+	}
+	return b, nil
+}
+
+func (c *Cluster) download(ctx context.Context, fid string, offset, length int64, p *Progress) ([]byte, *Error) {
+	var b []byte
+	err := c.downloadFrom(fid, func(s *Storage, filename string) (err *Error) {
+		b, err = s.download(ctx, filename, offset, length, p)
+		return err
+	})
 	if err != nil {
-		return nil, c.wrapError(err)
+		return nil, err
 	}
+	return b, nil
+}
 
-	//get a storage client from storage map, if not exist, create a new storage client
-	s, err := c.Storage(storeInfo)
+// DownloadInto download len(dst) bytes from offset into dst and return bytes received.
+//
+// DownloadInto is a wrapper of DefaultCluster.DownloadInto.
+func DownloadInto(fid string, dst []byte, offset int64) (int, error) {
+	return DefaultCluster.DownloadInto(fid, dst, offset)
+}
+
+// DownloadInto download len(dst) bytes from offset into dst and return bytes received.
+// It lets caller reuse its own buffers instead of allocating a new slice per download.
+// It fails if len(dst) exceeds DownloadSizeLimit of the storage, split larger reads.
+// Bytes are received as stored, a compressed file is not decompressed.
+func (c *Cluster) DownloadInto(fid string, dst []byte, offset int64) (int, error) {
+	var n int
+	err := c.downloadFrom(fid, func(s *Storage, filename string) (err *Error) {
+		n, err = s.DownloadInto(filename, dst, offset)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Modify overwrite the appender file with b from offset.
+//
+// Modify is a wrapper of DefaultCluster.Modify.
+func Modify(b []byte, fid string, offset int64) error {
+	return DefaultCluster.Modify(b, fid, offset)
+}
+
+// Modify overwrite the appender file with b from offset. Offset must not exceed file size.
+func (c *Cluster) Modify(b []byte, fid string, offset int64) error {
+	s, filename, err := c.updateStorage(fid)
+	if err != nil {
+		return err
+	}
+	err = s.Modify(b, filename, offset)
+	c.invalidateCache(fid)
+	if err != nil {
+		return c.wrapError(err)
+	}
+	return nil
+}
+
+// QueryFileInfo query file size, create time, crc32 and source ip of the file.
+//
+// QueryFileInfo is a wrapper of DefaultCluster.QueryFileInfo.
+func QueryFileInfo(fid string) (*FileInfo, error) {
+	return DefaultCluster.QueryFileInfo(fid)
+}
+
+// QueryFileInfo query file size, create time, crc32 and source ip of the file.
+func (c *Cluster) QueryFileInfo(fid string) (*FileInfo, error) {
+	info, err := c.queryFileInfo(fid)
 	if err != nil {
 		return nil, err
 	}
-	b, err := s.Download(filename, offset, length)
+	return info, nil
+}
+
+func (c *Cluster) queryFileInfo(fid string) (*FileInfo, *Error) {
+	var info *FileInfo
+	err := c.downloadFrom(fid, func(s *Storage, filename string) (err *Error) {
+		info, err = s.QueryFileInfo(filename)
+		return err
+	})
 	if err != nil {
-		return nil, c.wrapError(err)
+		return nil, err
 	}
+	return info, nil
+}
 
-	return b, nil
+// SetMetadata set metadata of the file.
+//
+// SetMetadata is a wrapper of DefaultCluster.SetMetadata.
+func SetMetadata(fid string, meta map[string]string, flag byte) error {
+	return DefaultCluster.SetMetadata(fid, meta, flag)
+}
+
+// SetMetadata set metadata of the file. Flag is STORAGE_SET_METADATA_FLAG_OVERWRITE to replace
+// all old metadata, or STORAGE_SET_METADATA_FLAG_MERGE to insert or update the given items.
+func (c *Cluster) SetMetadata(fid string, meta map[string]string, flag byte) error {
+	if err := c.setMetadata(fid, meta, flag); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Cluster) setMetadata(fid string, meta map[string]string, flag byte) *Error {
+	s, filename, err := c.updateStorage(fid)
+	if err != nil {
+		return err
+	}
+	return c.wrapError(s.SetMetadata(filename, meta, flag))
+}
+
+// GetMetadata return metadata of the file.
+//
+// GetMetadata is a wrapper of DefaultCluster.GetMetadata.
+func GetMetadata(fid string) (map[string]string, error) {
+	return DefaultCluster.GetMetadata(fid)
+}
+
+// GetMetadata return metadata of the file. It is empty if no metadata is set.
+func (c *Cluster) GetMetadata(fid string) (map[string]string, error) {
+	meta, err := c.getMetadata(fid)
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (c *Cluster) getMetadata(fid string) (map[string]string, *Error) {
+	var meta map[string]string
+	err := c.downloadFrom(fid, func(s *Storage, filename string) (err *Error) {
+		meta, err = s.GetMetadata(filename)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// SetCache enable, reconfigure or disable download cache. Default is disabled.
+//
+// SetCache is a wrapper of DefaultCluster.SetCache.
+func SetCache(config CacheConfig) error {
+	return DefaultCluster.SetCache(config)
+}
+
+// SetCache enable, reconfigure or disable download cache. Default is disabled.
+//
+// Download and DownloadFromOffset of a whole file put its content into the cache, and later
+// downloads of the file or any range of it are served from the cache. Appender files are not cached
+// because they can change. Delete, Append, Modify and Truncate through the cluster drop the cached file.
+// Files changed by other clients are not noticed. Set an empty config to disable the cache.
+func (c *Cluster) SetCache(config CacheConfig) error {
+	var fc *fileCache
+	if config.MemorySize > 0 || config.DiskDir != "" {
+		var err error
+		if fc, err = newFileCache(config); err != nil {
+			return c.wrapError(cacheErr(err))
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.cache = fc
+	return nil
+}
+
+func (c *Cluster) fileCache() *fileCache {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.cache
+}
+
+// invalidateCache drop cached content of fid
+func (c *Cluster) invalidateCache(fid string) {
+	if fc := c.fileCache(); fc != nil {
+		fc.remove(fid)
+	}
+}
+
+// SetHedging enable, change or disable download hedging. Default is disabled.
+//
+// SetHedging is a wrapper of DefaultCluster.SetHedging.
+func SetHedging(policy HedgePolicy) {
+	DefaultCluster.SetHedging(policy)
+}
+
+// SetHedging enable, change or disable download hedging. Default is disabled.
+//
+// When enabled, Download, DownloadFromOffset and DownloadContext query all storage holding the file.
+// If the chosen one has not responded after the policy delay, the same request is sent to another,
+// the first success is returned and the other download is aborted. It cuts tail latency caused by
+// occasional slow storage at the cost of extra load. Set an empty policy to disable it.
+func (c *Cluster) SetHedging(policy HedgePolicy) {
+	var h *hedger
+	if policy.Delay > 0 || policy.Percentile > 0 {
+		h = newHedger(policy)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.hedge = h
+}
+
+func (c *Cluster) hedger() *hedger {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.hedge
+}
+
+// SetReadAfterWrite set the window files are read from their source storage after upload.
+// Default is 0 and disabled.
+//
+// SetReadAfterWrite is a wrapper of DefaultCluster.SetReadAfterWrite.
+func SetReadAfterWrite(window time.Duration) {
+	DefaultCluster.SetReadAfterWrite(window)
+}
+
+// SetReadAfterWrite set the window files are read from their source storage after upload.
+// Default is 0 and disabled.
+//
+// A storage returned by tracker may not have synced a new file yet. Files created within the
+// window, according to the create time in their fid, are downloaded and queried from the source
+// storage in their fid instead, which is assumed to listen on the same port as the other storage
+// of the group. If the source storage is unreachable or does not have the file, the storage returned
+// by tracker is tried. Set the window a little longer than sync delay of the cluster plus clock skew.
+func (c *Cluster) SetReadAfterWrite(window time.Duration) {
+	atomic.StoreInt64(&c.syncWindow, int64(window))
+}
+
+// recentUpload return decoded fid if the file is created within read after write window
+func (c *Cluster) recentUpload(fid string) (*FileID, bool) {
+	window := time.Duration(atomic.LoadInt64(&c.syncWindow))
+	if window <= 0 {
+		return nil, false
+	}
+	id, err := DecodeFileID(fid)
+	if err != nil || time.Since(id.CreateTime) > window {
+		return nil, false
+	}
+	return id, true
+}
+
+// sourceStorage return storage info of the source storage if the file is created within
+// read after write window and info is another storage. The fid encodes only the source IP,
+// the source is assumed to listen on the port of the storage tracker chose, as storages of
+// a group usually do.
+func (c *Cluster) sourceStorage(fid string, info *TrackerStoreInfo) (*TrackerStoreInfo, bool) {
+	id, ok := c.recentUpload(fid)
+	if !ok {
+		return nil, false
+	}
+	host, port, e := net.SplitHostPort(info.Address)
+	if e != nil || host == id.SourceIP {
+		return nil, false
+	}
+	return &TrackerStoreInfo{Address: net.JoinHostPort(id.SourceIP, port), Group: info.Group}, true
+}
+
+// SetCoalescing enable or disable download coalescing. Default is disabled.
+//
+// SetCoalescing is a wrapper of DefaultCluster.SetCoalescing.
+func SetCoalescing(enabled bool) {
+	DefaultCluster.SetCoalescing(enabled)
+}
+
+// SetCoalescing enable or disable download coalescing. Default is disabled.
+//
+// When enabled, concurrent Download, DownloadFromOffset and DownloadContext calls with the same
+// fid, offset and length share one tracker query and storage download, and all receive its result.
+// It helps when a popular file is requested by many goroutines at once.
+func (c *Cluster) SetCoalescing(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&c.coalescing, v)
+}
+
+func (c *Cluster) isCoalescing() bool {
+	return atomic.LoadInt32(&c.coalescing) == 1
 }
 
 // StorageGroup query a storage group from cluster storage group map.
@@ -187,6 +635,8 @@ func (c *Cluster) Storage(info *TrackerStoreInfo) (*Storage, *Error) {
 	if err != nil {
 		return nil, c.wrapError(err)
 	}
+	s.clusterUploadLimiter = c.uploadLimiter
+	s.clusterDownloadLimiter = c.downloadLimiter
 	sg.Add(s)
 	return s, nil
 }
@@ -200,6 +650,27 @@ func (c *Cluster) Tracker() *Tracker {
 	return c.trackerPeers[rand.Intn(len(c.trackerPeers))]
 }
 
+// Truncate the appender file to size bytes.
+//
+// Truncate is a wrapper of DefaultCluster.Truncate.
+func Truncate(fid string, size int64) error {
+	return DefaultCluster.Truncate(fid, size)
+}
+
+// Truncate the appender file to size bytes.
+func (c *Cluster) Truncate(fid string, size int64) error {
+	s, filename, err := c.updateStorage(fid)
+	if err != nil {
+		return err
+	}
+	err = s.Truncate(filename, size)
+	c.invalidateCache(fid)
+	if err != nil {
+		return c.wrapError(err)
+	}
+	return nil
+}
+
 // UpdateStorageGroup update existing group's storage config or create a new group use the config.
 //
 // UpdateStorageGroup is a wrapper of DefaultCluster.UpdateStorageGroup.
@@ -217,15 +688,17 @@ func (c *Cluster) UpdateStorageGroup(group string, config StorageConfig) {
 	}
 }
 
-// UpdateTracker update all tracker peers config.
+// UpdateTracker update all tracker peers config, cluster bandwidth limit and route cache.
 //
 // UpdateTracker is a wrapper of DefaultCluster.UpdateTracker.
 func UpdateTracker(config TrackerConfig) {
 	DefaultCluster.UpdateTracker(config)
 }
 
-// UpdateTracker update all tracker peers config.
+// UpdateTracker update all tracker peers config, cluster bandwidth limit and route cache.
 func (c *Cluster) UpdateTracker(config TrackerConfig) {
+	c.updateClusterConfig(config)
+
 	c.mtx.RLock()
 	defer c.mtx.RUnlock()
 
@@ -234,19 +707,34 @@ func (c *Cluster) UpdateTracker(config TrackerConfig) {
 	}
 }
 
-func (c *Cluster) upload(b []byte, group, ext string, allowAppend bool) (string, *Error) {
-	//query a upload server from tracker
-	t := c.Tracker()
-	info, err := t.QueryUploadStorage(group)
+// updateClusterConfig update cluster bandwidth limiters and route cache if set in config
+func (c *Cluster) updateClusterConfig(config TrackerConfig) {
+	if config.UploadRateLimit != 0 {
+		c.uploadLimiter.setRate(config.UploadRateLimit)
+	}
+	if config.DownloadRateLimit != 0 {
+		c.downloadLimiter.setRate(config.DownloadRateLimit)
+	}
+	if config.RouteCacheTTL != 0 || config.RouteCacheSize > 0 {
+		c.routes.update(config.RouteCacheTTL, config.RouteCacheSize)
+	}
+}
+
+func (c *Cluster) upload(b []byte, group, ext string, allowAppend bool, p *Progress) (string, *Error) {
+	//query a upload server from tracker or route cache
+	info, err := c.queryUploadStorage(group)
 	if err != nil {
-		return "", c.wrapError(err)
+		return "", err
 	}
 	//get a storage client from storage map, if not exist, create a new storage client
 	s, err := c.Storage(info)
 	if err != nil {
 		return "", err
 	}
-	fid, err := s.Upload(b, info.PathIndex, ext, allowAppend)
+	fid, err := s.UploadWithProgress(b, info.PathIndex, ext, allowAppend, p)
+	if err != nil {
+		c.routes.invalidateUpload(group, info.Address)
+	}
 	return fid, c.wrapError(err)
 }
 
@@ -262,8 +750,20 @@ func Upload(b []byte, group, ext string) (string, error) {
 // Upload a file to the group with specified extension name.
 // The upload cannot be appended bytes to.
 // If you need to append bytes later, use UploadAppender method instead.
+//
+// If compression is enabled, the file may be stored compressed, see SetCompression.
 func (c *Cluster) Upload(b []byte, group, ext string) (string, error) {
-	return c.upload(b, group, ext, false)
+	var fid string
+	var err *Error
+	if cd := c.compression(); cd != nil {
+		fid, err = c.uploadCompressed(cd, b, group, ext)
+	} else {
+		fid, err = c.upload(b, group, ext, false, nil)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
 }
 
 // uploadAppender upload a file to the group with specified extension name.
@@ -277,7 +777,114 @@ func UploadAppender(b []byte, group, ext string) (string, error) {
 // uploadAppender upload a file to the group with specified extension name.
 // The uploaded file can be appended bytes to.
 func (c *Cluster) UploadAppender(b []byte, group, ext string) (string, error) {
-	return c.upload(b, group, ext, true)
+	return c.upload(b, group, ext, true, nil)
+}
+
+// UploadFile upload the file content from current offset to the end.
+// The upload cannot be appended bytes to.
+//
+// UploadFile is a wrapper of DefaultCluster.UploadFile.
+func UploadFile(f *os.File, group, ext string) (string, error) {
+	return DefaultCluster.UploadFile(f, group, ext)
+}
+
+// UploadFile upload the file content from current offset to the end.
+// The upload cannot be appended bytes to.
+//
+// The content is sent by sendfile on linux, so large files do not cost user space copies.
+func (c *Cluster) UploadFile(f *os.File, group, ext string) (string, error) {
+	return c.UploadFileWithProgress(f, group, ext, nil)
+}
+
+// UploadFileWithProgress upload the file content from current offset to the end and report progress to p.
+//
+// UploadFileWithProgress is a wrapper of DefaultCluster.UploadFileWithProgress.
+func UploadFileWithProgress(f *os.File, group, ext string, p *Progress) (string, error) {
+	return DefaultCluster.UploadFileWithProgress(f, group, ext, p)
+}
+
+// UploadFileWithProgress upload the file content from current offset to the end and report progress to p.
+// The upload cannot be appended bytes to. It is sent by sendfile in steps of p.
+func (c *Cluster) UploadFileWithProgress(f *os.File, group, ext string, p *Progress) (string, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return "", c.wrapError(localFileErr(err))
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", c.wrapError(localFileErr(err))
+	}
+	fid, e := c.uploadReader(f, st.Size()-offset, group, ext, false, p)
+	if e != nil {
+		return "", e
+	}
+	return fid, nil
+}
+
+// UploadReader upload size bytes read from r. The upload cannot be appended bytes to.
+//
+// UploadReader is a wrapper of DefaultCluster.UploadReader.
+func UploadReader(r io.Reader, size int64, group, ext string) (string, error) {
+	return DefaultCluster.UploadReader(r, size, group, ext)
+}
+
+// UploadReader upload size bytes read from r. The upload cannot be appended bytes to.
+// If r is an *os.File, UploadFile is recommended.
+func (c *Cluster) UploadReader(r io.Reader, size int64, group, ext string) (string, error) {
+	return c.UploadReaderWithProgress(r, size, group, ext, nil)
+}
+
+// UploadReaderWithProgress upload size bytes read from r and report progress to p.
+//
+// UploadReaderWithProgress is a wrapper of DefaultCluster.UploadReaderWithProgress.
+func UploadReaderWithProgress(r io.Reader, size int64, group, ext string, p *Progress) (string, error) {
+	return DefaultCluster.UploadReaderWithProgress(r, size, group, ext, p)
+}
+
+// UploadReaderWithProgress upload size bytes read from r and report progress to p.
+// The upload cannot be appended bytes to.
+func (c *Cluster) UploadReaderWithProgress(r io.Reader, size int64, group, ext string, p *Progress) (string, error) {
+	fid, err := c.uploadReader(r, size, group, ext, false, p)
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
+}
+
+func (c *Cluster) uploadReader(r io.Reader, size int64, group, ext string, allowAppend bool, p *Progress) (string, *Error) {
+	//query a upload server from tracker or route cache
+	info, err := c.queryUploadStorage(group)
+	if err != nil {
+		return "", err
+	}
+	//get a storage client from storage map, if not exist, create a new storage client
+	s, err := c.Storage(info)
+	if err != nil {
+		return "", err
+	}
+	fid, err := s.UploadReaderWithProgress(r, size, info.PathIndex, ext, allowAppend, p)
+	if err != nil {
+		c.routes.invalidateUpload(group, info.Address)
+	}
+	return fid, c.wrapError(err)
+}
+
+// UploadWithProgress upload a file to the group with specified extension name and report progress to p.
+// The upload cannot be appended bytes to.
+//
+// UploadWithProgress is a wrapper of DefaultCluster.UploadWithProgress.
+func UploadWithProgress(b []byte, group, ext string, p *Progress) (string, error) {
+	return DefaultCluster.UploadWithProgress(b, group, ext, p)
+}
+
+// UploadWithProgress upload a file to the group with specified extension name and report progress to p.
+// The upload cannot be appended bytes to.
+func (c *Cluster) UploadWithProgress(b []byte, group, ext string, p *Progress) (string, error) {
+	fid, err := c.upload(b, group, ext, false, p)
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
 }
 
 // UploadSlave upload as a slave file of master, slave file id is {master}{suffix}.{ext}.
@@ -317,6 +924,102 @@ func (c *Cluster) splitFid(fid string) (string, string, *Error) {
 	return s[0], s[1], nil
 }
 
+// queryUploadStorage return upload storage info of group. If route cache is enabled,
+// all upload storage of the group are queried once and used in turn until expired.
+func (c *Cluster) queryUploadStorage(group string) (*TrackerStoreInfo, *Error) {
+	if !c.routes.enabled() {
+		info, err := c.Tracker().QueryUploadStorage(group)
+		return info, c.wrapError(err)
+	}
+	if info, ok := c.routes.uploadStorage(group); ok {
+		return info, nil
+	}
+	infos, err := c.Tracker().QueryUploadStorageAll(group)
+	if err != nil {
+		return nil, c.wrapError(err)
+	}
+	return c.routes.setUploadStorage(group, infos), nil
+}
+
+// downloadFrom call do with the storage to download the file from and the file name in group.
+// If read after write applies, the source storage in fid is tried first, then the storage tracker
+// chose if the source is unreachable or does not have the file. The route of a failed call is invalidated.
+func (c *Cluster) downloadFrom(fid string, do func(s *Storage, filename string) *Error) *Error {
+	info, filename, err := c.downloadStorage(fid)
+	if err != nil {
+		return err
+	}
+	//a new file may not be synced to the storage yet
+	if src, ok := c.sourceStorage(fid, info); ok {
+		err = c.downloadFromStorage(src, filename, do)
+		if err == nil || !(isFileNotExist(err) || isConnFailure(err)) {
+			return c.downloadFailed(fid, err)
+		}
+	}
+	return c.downloadFailed(fid, c.downloadFromStorage(info, filename, do))
+}
+
+func (c *Cluster) downloadFromStorage(info *TrackerStoreInfo, filename string, do func(s *Storage, filename string) *Error) *Error {
+	//get a storage client from storage map, if not exist, create a new storage client
+	s, err := c.Storage(info)
+	if err != nil {
+		return err
+	}
+	return c.wrapError(do(s, filename))
+}
+
+// downloadFailed invalidate the route of fid unless err is nil or caused by a done context
+func (c *Cluster) downloadFailed(fid string, err *Error) *Error {
+	if err != nil && !isContextErr(err) {
+		c.routes.invalidateDownload(fid)
+	}
+	return err
+}
+
+// downloadStorage return storage info to download the file from and the file name in group.
+// The storage comes from route cache if enabled, or from tracker.
+func (c *Cluster) downloadStorage(fid string) (*TrackerStoreInfo, string, *Error) {
+	//split file id to two parts: group name and file name
+	group, filename, err := c.splitFid(fid)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := c.checkTrash(fid); err != nil {
+		return nil, "", err
+	}
+	info, ok := c.routes.downloadStorage(fid)
+	if !ok {
+		//query a download server from tracker
+		info, err = c.Tracker().QueryDownloadStorage(group, filename)
+		if err != nil {
+			return nil, "", c.wrapError(err)
+		}
+		if c.routes.enabled() {
+			c.routes.setDownloadStorage(fid, info)
+		}
+	}
+	return info, filename, nil
+}
+
+// updateStorage return the storage accepting update actions of the file and the file name in group
+func (c *Cluster) updateStorage(fid string) (*Storage, string, *Error) {
+	group, filename, err := c.splitFid(fid)
+	if err != nil {
+		return nil, "", err
+	}
+	//query a upload server from tracker
+	info, err := c.Tracker().QueryUpdateStorage(group, filename)
+	if err != nil {
+		return nil, "", c.wrapError(err)
+	}
+	//get a storage client from storage map, if not exist, create a new storage client
+	s, err := c.Storage(info)
+	if err != nil {
+		return nil, "", err
+	}
+	return s, filename, nil
+}
+
 // wrapError wrap cluster name to error
 func (c *Cluster) wrapError(err *Error) *Error {
 	if err == nil {
@@ -0,0 +1,66 @@
+package cluster
+
+import (
+	"sync"
+)
+
+// flightKey identifies identical download requests
+type flightKey struct {
+	fid    string
+	offset int64
+	length int64
+}
+
+// flightCall is a download in flight, shared by all callers of the same key
+type flightCall struct {
+	done chan struct{}
+	b    []byte
+	err  *Error
+}
+
+// result return the shared bytes and a copy of the error, so callers can wrap it independently
+func (fc *flightCall) result() ([]byte, *Error) {
+	if fc.err == nil {
+		return fc.b, nil
+	}
+	err := *fc.err
+	return fc.b, &err
+}
+
+// flightGroup coalesces concurrent downloads of the same key into one fetch
+type flightGroup struct {
+	calls map[flightKey]*flightCall
+
+	mtx sync.Mutex
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[flightKey]*flightCall)}
+}
+
+// start run fn in background and return its call. If share is true and a call of the same key
+// is in flight, that call is returned instead of starting a new one.
+func (g *flightGroup) start(key flightKey, share bool, fn func() ([]byte, *Error)) *flightCall {
+	if share {
+		g.mtx.Lock()
+		defer g.mtx.Unlock()
+
+		if fc, ok := g.calls[key]; ok {
+			return fc
+		}
+	}
+	fc := &flightCall{done: make(chan struct{})}
+	if share {
+		g.calls[key] = fc
+	}
+	go func() {
+		fc.b, fc.err = fn()
+		if share {
+			g.mtx.Lock()
+			delete(g.calls, key)
+			g.mtx.Unlock()
+		}
+		close(fc.done)
+	}()
+	return fc
+}
@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	c := New("test")
+	key := flightKey{fid: "g1/M00/00/00/popular.jpg"}
+	content := []byte("popular")
+
+	//the download blocks until released, so all callers join the same flight
+	var downloads int32
+	release := make(chan struct{})
+	download := func() ([]byte, *Error) {
+		atomic.AddInt32(&downloads, 1)
+		<-release
+		return content, nil
+	}
+
+	//a canceled caller returns at once
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan *Error)
+	go func() {
+		_, err := c.coalesce(ctx, key, download)
+		canceled <- err
+	}()
+	for atomic.LoadInt32(&downloads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-canceled; !isContextErr(err) {
+		t.Fatalf("canceled caller: %v", err)
+	}
+
+	var wg, started sync.WaitGroup
+	results := make([][]byte, 10)
+	errs := make([]*Error, 10)
+	for i := range results {
+		wg.Add(1)
+		started.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			started.Done()
+			results[i], errs[i] = c.coalesce(context.Background(), key, download)
+		}(i)
+	}
+	//let all callers join the flight
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("%d downloads", n)
+	}
+	for i := range results {
+		if errs[i] != nil || !bytes.Equal(results[i], content) {
+			t.Errorf("caller %d: %q, %v", i, results[i], errs[i])
+		}
+	}
+
+	//the next request after the flight starts a new download
+	if _, err := c.coalesce(context.Background(), key, download); err != nil || atomic.LoadInt32(&downloads) != 2 {
+		t.Errorf("download after flight: %d downloads, %v", downloads, err)
+	}
+}
@@ -0,0 +1,246 @@
+package cluster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// CompressionGzip compress files by gzip
+	CompressionGzip = "gzip"
+
+	// metaCodec is metadata name of the algorithm a file is compressed by
+	metaCodec = "fdfs_codec"
+	// metaRawSize is metadata name of the size before compression
+	metaRawSize = "fdfs_raw_size"
+
+	// defaultCompressMinSize is size files smaller than are stored as is if not set
+	defaultCompressMinSize = 1024
+)
+
+// defaultSkipExts are extension names of already compressed content
+var defaultSkipExts = []string{
+	"jpg", "jpeg", "png", "gif", "webp", "heic",
+	"mp3", "m4a", "aac", "ogg", "mp4", "m4v", "mov", "avi", "mkv", "flv", "webm",
+	"zip", "gz", "tgz", "bz2", "xz", "zst", "lz4", "7z", "rar", "jar", "apk",
+	"pdf", "docx", "xlsx", "pptx", "woff", "woff2",
+}
+
+// skipContentTypes are prefixes of sniffed content types already compressed
+var skipContentTypes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp", "video/", "audio/",
+	"application/zip", "application/x-gzip", "application/x-rar-compressed", "application/pdf", "font/woff",
+}
+
+// CompressionConfig defines how Upload compresses files.
+type CompressionConfig struct {
+	// Algorithm is CompressionGzip, or empty to disable compression. zstd is not supported
+	// since it is not in standard library.
+	Algorithm string
+
+	// Level is gzip compression level. Default gzip.DefaultCompression.
+	Level int
+
+	// MinSize is size files smaller than are stored as is. Default 1024.
+	MinSize int
+
+	// SkipExts are extension names of already compressed content, stored as is. Content is sniffed
+	// as well, so compressed images, media and archives are skipped even with other names.
+	// Default is common image, media, archive and office formats.
+	SkipExts []string
+}
+
+// codec compresses files on upload
+type codec struct {
+	level    int
+	minSize  int
+	skipExts map[string]bool
+}
+
+func newCodec(config CompressionConfig) (*codec, error) {
+	if config.Algorithm != CompressionGzip {
+		return nil, fmt.Errorf("unsupported compression algorithm %q", config.Algorithm)
+	}
+	if config.Level == 0 {
+		config.Level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(ioutil.Discard, config.Level); err != nil {
+		return nil, err
+	}
+	if config.MinSize <= 0 {
+		config.MinSize = defaultCompressMinSize
+	}
+	if config.SkipExts == nil {
+		config.SkipExts = defaultSkipExts
+	}
+	cd := &codec{level: config.Level, minSize: config.MinSize, skipExts: make(map[string]bool)}
+	for _, ext := range config.SkipExts {
+		cd.skipExts[strings.ToLower(ext)] = true
+	}
+	return cd, nil
+}
+
+// skip return true if b should be stored as is
+func (cd *codec) skip(b []byte, ext string) bool {
+	if len(b) < cd.minSize || cd.skipExts[strings.ToLower(ext)] {
+		return true
+	}
+	contentType := http.DetectContentType(b)
+	for _, prefix := range skipContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compress return compressed b, or false if compression saves less than 1/10
+func (cd *codec) compress(b []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, cd.level)
+	if _, err := w.Write(b); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() > len(b)-len(b)/10 {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompress b by the algorithm in metadata
+func decompress(b []byte, meta map[string]string) ([]byte, error) {
+	if meta[metaCodec] != CompressionGzip {
+		return nil, fmt.Errorf("unsupported compression algorithm %q", meta[metaCodec])
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	if size, err := strconv.Atoi(meta[metaRawSize]); err == nil && size > 0 {
+		buf.Grow(size)
+	}
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isGzip return true if b starts with gzip magic number
+func isGzip(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
+
+// SetCompression enable, reconfigure or disable compression of Upload. Default is disabled.
+//
+// SetCompression is a wrapper of DefaultCluster.SetCompression.
+func SetCompression(config CompressionConfig) error {
+	return DefaultCluster.SetCompression(config)
+}
+
+// SetCompression enable, reconfigure or disable compression of Upload. Default is disabled.
+//
+// When enabled, Upload compresses the file and records the algorithm in its metadata,
+// unless the file is small, already compressed or does not compress well. Once compression
+// is enabled, Download and DownloadWithProgress decompress such files even after it is disabled,
+// use DownloadRaw to get the stored bytes. The other download methods return stored bytes.
+// Appender files and the other upload methods are always stored as is.
+//
+// Metadata is synced to other storages after the file, enable SetReadAfterWrite if files are
+// downloaded right after upload.
+func (c *Cluster) SetCompression(config CompressionConfig) error {
+	var cd *codec
+	if config.Algorithm != "" {
+		var err error
+		if cd, err = newCodec(config); err != nil {
+			return c.wrapError(codecErr(err))
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.codec = cd
+	if cd != nil {
+		atomic.StoreInt32(&c.decoding, 1)
+	}
+	return nil
+}
+
+func (c *Cluster) compression() *codec {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.codec
+}
+
+// uploadCompressed upload b compressed if it is worth it
+func (c *Cluster) uploadCompressed(cd *codec, b []byte, group, ext string) (string, *Error) {
+	if cd.skip(b, ext) {
+		return c.upload(b, group, ext, false, nil)
+	}
+	z, ok := cd.compress(b)
+	if !ok {
+		return c.upload(b, group, ext, false, nil)
+	}
+	fid, err := c.upload(z, group, ext, false, nil)
+	if err != nil {
+		return "", err
+	}
+	meta := map[string]string{metaCodec: CompressionGzip, metaRawSize: strconv.Itoa(len(b))}
+	if err := c.setMetadata(fid, meta, STORAGE_SET_METADATA_FLAG_MERGE); err != nil {
+		//without metadata the file cannot be decompressed
+		c.delete(fid)
+		return "", err
+	}
+	return fid, nil
+}
+
+// decode decompress downloaded bytes of the whole file if it is compressed by Upload.
+// Nothing is done unless compression has been enabled, and only gzip data is looked up
+// in metadata, so other files cost no extra request.
+func (c *Cluster) decode(fid string, b []byte) ([]byte, *Error) {
+	if atomic.LoadInt32(&c.decoding) == 0 || !isGzip(b) {
+		return b, nil
+	}
+	meta, err := c.getMetadata(fid)
+	if err != nil {
+		return nil, err
+	}
+	if meta[metaCodec] == "" {
+		//a gzip file uploaded as is
+		return b, nil
+	}
+	raw, e := decompress(b, meta)
+	if e != nil {
+		return nil, c.wrapError(codecErr(e))
+	}
+	return raw, nil
+}
+
+// DownloadRaw download the whole file as stored, without decompression.
+//
+// DownloadRaw is a wrapper of DefaultCluster.DownloadRaw.
+func DownloadRaw(fid string) ([]byte, error) {
+	return DefaultCluster.DownloadRaw(fid)
+}
+
+// DownloadRaw download the whole file as stored, without decompression.
+func (c *Cluster) DownloadRaw(fid string) ([]byte, error) {
+	b, err := c.DownloadFromOffset(fid, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
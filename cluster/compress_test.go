@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	if _, err := newCodec(CompressionConfig{Algorithm: "zstd"}); err == nil {
+		t.Fatal("zstd should not be supported")
+	}
+	cd, err := newCodec(CompressionConfig{Algorithm: CompressionGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := bytes.Repeat([]byte(`{"level":"info","msg":"request served"}`+"\n"), 100)
+	if cd.skip(text, "log") {
+		t.Fatal("text log should be compressed")
+	}
+	if !cd.skip(text, "GZ") || !cd.skip(text[:100], "log") {
+		t.Fatal("compressed extension and small file should be skipped")
+	}
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), text...)
+	if !cd.skip(png, "bin") {
+		t.Fatal("sniffed png should be skipped")
+	}
+
+	z, ok := cd.compress(text)
+	if !ok || !isGzip(z) || len(z) >= len(text) {
+		t.Fatalf("compress text, got %d bytes, %v", len(z), ok)
+	}
+	raw, err := decompress(z, map[string]string{metaCodec: CompressionGzip, metaRawSize: strconv.Itoa(len(text))})
+	if err != nil || !bytes.Equal(raw, text) {
+		t.Fatalf("decompress, got %d bytes, %v", len(raw), err)
+	}
+	if _, ok := cd.compress(z); ok {
+		t.Fatal("compressed data should not be compressed again")
+	}
+}
+
+func TestDecodeNeverEnabled(t *testing.T) {
+	cd, err := newCodec(CompressionConfig{Algorithm: CompressionGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, ok := cd.compress(bytes.Repeat([]byte("fastdfs "), 1000))
+	if !ok {
+		t.Fatal("repeated text should compress")
+	}
+	//a gzip file uploaded as is, decode must not look up metadata
+	b, e := New("test").decode("g1/M00/00/00/CgIG6VuXIoeAbiwbAAAIIRe5FG4412.gz", z)
+	if e != nil || !bytes.Equal(b, z) {
+		t.Fatalf("decode without compression enabled, got %d bytes, %v", len(b), e)
+	}
+}
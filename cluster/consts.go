@@ -122,6 +122,8 @@ const (
 
 	TRACKER_QUERY_STORAGE_FETCH_BODY_LEN = (FDFS_GROUP_NAME_MAX_LEN + IP_ADDRESS_SIZE - 1 + FDFS_PROTO_PKG_LEN_SIZE)
 	TRACKER_QUERY_STORAGE_STORE_BODY_LEN = (FDFS_GROUP_NAME_MAX_LEN + IP_ADDRESS_SIZE - 1 + FDFS_PROTO_PKG_LEN_SIZE + 1)
+	STORAGE_QUERY_FILE_INFO_BODY_LEN     = (3*FDFS_PROTO_PKG_LEN_SIZE + IP_ADDRESS_SIZE)
+	TRACKER_GROUP_STAT_LEN               = (FDFS_GROUP_NAME_MAX_LEN + 1 + 11*FDFS_PROTO_PKG_LEN_SIZE)
 	//status code, order is important!
 	FDFS_STORAGE_STATUS_INIT       = 0
 	FDFS_STORAGE_STATUS_WAIT_SYNC  = 1
@@ -0,0 +1,321 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DedupIndex maps content keys to fids with reference counts. Implementations must be safe for
+// concurrent use.
+type DedupIndex interface {
+	// Acquire add a reference to the fid of key and return it. It returns empty fid if key is not indexed.
+	Acquire(key string) (string, error)
+
+	// Put index fid of key with one reference. If key is already indexed, a reference is added to the
+	// indexed fid and it is returned instead, the caller should delete its own duplicate.
+	Put(key, fid string) (string, error)
+
+	// Release remove a reference of fid and return references left. The fid is removed from index when
+	// no reference left. A fid not indexed has 0 reference left.
+	Release(fid string) (int, error)
+}
+
+// dedupEntry is an indexed fid and its references
+type dedupEntry struct {
+	Fid  string `json:"fid"`
+	Refs int    `json:"refs"`
+}
+
+// MemoryDedupIndex keeps index in memory. It is lost when the process exits.
+type MemoryDedupIndex struct {
+	entries map[string]*dedupEntry
+	// keys maps fid to its key
+	keys map[string]string
+
+	mtx sync.Mutex
+}
+
+// NewMemoryDedupIndex create an empty memory index.
+func NewMemoryDedupIndex() *MemoryDedupIndex {
+	return &MemoryDedupIndex{
+		entries: make(map[string]*dedupEntry),
+		keys:    make(map[string]string),
+	}
+}
+
+// Acquire add a reference to the fid of key.
+func (mi *MemoryDedupIndex) Acquire(key string) (string, error) {
+	mi.mtx.Lock()
+	defer mi.mtx.Unlock()
+
+	fid, _ := mi.acquire(key)
+	return fid, nil
+}
+
+// Put index fid of key, or add a reference to the indexed fid.
+func (mi *MemoryDedupIndex) Put(key, fid string) (string, error) {
+	mi.mtx.Lock()
+	defer mi.mtx.Unlock()
+
+	return mi.put(key, fid), nil
+}
+
+// Release remove a reference of fid.
+func (mi *MemoryDedupIndex) Release(fid string) (int, error) {
+	mi.mtx.Lock()
+	defer mi.mtx.Unlock()
+
+	refs, _ := mi.release(fid)
+	return refs, nil
+}
+
+// acquire return fid of key and true if the index is changed
+func (mi *MemoryDedupIndex) acquire(key string) (string, bool) {
+	e, ok := mi.entries[key]
+	if !ok {
+		return "", false
+	}
+	e.Refs++
+	return e.Fid, true
+}
+
+func (mi *MemoryDedupIndex) put(key, fid string) string {
+	if existing, ok := mi.acquire(key); ok {
+		return existing
+	}
+	mi.entries[key] = &dedupEntry{Fid: fid, Refs: 1}
+	mi.keys[fid] = key
+	return fid
+}
+
+// release return references left and true if the index is changed
+func (mi *MemoryDedupIndex) release(fid string) (int, bool) {
+	key, ok := mi.keys[fid]
+	if !ok {
+		return 0, false
+	}
+	e := mi.entries[key]
+	e.Refs--
+	if e.Refs <= 0 {
+		delete(mi.entries, key)
+		delete(mi.keys, fid)
+		return 0, true
+	}
+	return e.Refs, true
+}
+
+// FileDedupIndex keeps index in memory and saves it to a json file on every change.
+// It suits indexes of moderate size, use a database backed DedupIndex for large ones.
+type FileDedupIndex struct {
+	path  string
+	index *MemoryDedupIndex
+}
+
+// NewFileDedupIndex load index from the file, an empty index is created if the file not exist.
+func NewFileDedupIndex(path string) (*FileDedupIndex, error) {
+	fi := &FileDedupIndex{path: path, index: NewMemoryDedupIndex()}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fi, os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &fi.index.entries); err != nil {
+		return nil, err
+	}
+	for key, e := range fi.index.entries {
+		fi.index.keys[e.Fid] = key
+	}
+	return fi, nil
+}
+
+// Acquire add a reference to the fid of key.
+func (fi *FileDedupIndex) Acquire(key string) (string, error) {
+	fi.index.mtx.Lock()
+	defer fi.index.mtx.Unlock()
+
+	fid, changed := fi.index.acquire(key)
+	if changed {
+		if err := fi.save(); err != nil {
+			fi.index.release(fid)
+			return "", err
+		}
+	}
+	return fid, nil
+}
+
+// Put index fid of key, or add a reference to the indexed fid.
+func (fi *FileDedupIndex) Put(key, fid string) (string, error) {
+	fi.index.mtx.Lock()
+	defer fi.index.mtx.Unlock()
+
+	indexed := fi.index.put(key, fid)
+	if err := fi.save(); err != nil {
+		fi.index.release(indexed)
+		return "", err
+	}
+	return indexed, nil
+}
+
+// Release remove a reference of fid. The reference is removed from memory even if save fails,
+// so the file has at most extra references and never deletes a fid still in use.
+func (fi *FileDedupIndex) Release(fid string) (int, error) {
+	fi.index.mtx.Lock()
+	defer fi.index.mtx.Unlock()
+
+	refs, changed := fi.index.release(fid)
+	if changed {
+		if err := fi.save(); err != nil {
+			return refs, err
+		}
+	}
+	return refs, nil
+}
+
+// save write the index to file atomically
+func (fi *FileDedupIndex) save() error {
+	b, err := json.Marshal(fi.index.entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fi.path, b)
+}
+
+// SetDedupIndex set the index used by DedupUpload and DedupDelete.
+//
+// SetDedupIndex is a wrapper of DefaultCluster.SetDedupIndex.
+func SetDedupIndex(index DedupIndex) {
+	DefaultCluster.SetDedupIndex(index)
+}
+
+// SetDedupIndex set the index used by DedupUpload and DedupDelete.
+func (c *Cluster) SetDedupIndex(index DedupIndex) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.dedup = index
+}
+
+func (c *Cluster) dedupIndex() (DedupIndex, *Error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.dedup == nil {
+		return nil, c.wrapError(dedupErr(errors.New("dedup index is not set")))
+	}
+	return c.dedup, nil
+}
+
+// DedupUpload upload a file unless the same content is uploaded before.
+//
+// DedupUpload is a wrapper of DefaultCluster.DedupUpload.
+func DedupUpload(b []byte, group, ext string) (string, error) {
+	return DefaultCluster.DedupUpload(b, group, ext)
+}
+
+// DedupUpload upload a file to the group with specified extension name, unless the same content is
+// uploaded to the group with the same extension name before. Content is identified by SHA-256. If it
+// is in the dedup index and the indexed file still exists, it gets a reference and is returned without
+// uploading. An indexed file gone is dropped from the index with its references and uploaded again,
+// then DedupDelete of the gone fid reports the file does not exist.
+//
+// Files uploaded by DedupUpload are shared, delete them by DedupDelete only.
+func (c *Cluster) DedupUpload(b []byte, group, ext string) (string, error) {
+	fid, err := c.dedupUpload(b, group, ext)
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
+}
+
+func (c *Cluster) dedupUpload(b []byte, group, ext string) (string, *Error) {
+	index, err := c.dedupIndex()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	key := group + "/" + hex.EncodeToString(sum[:]) + "." + ext
+
+	fid, e := index.Acquire(key)
+	if e != nil {
+		return "", c.wrapError(dedupErr(e))
+	}
+	if fid != "" {
+		_, err := c.queryFileInfo(fid)
+		if err == nil {
+			return fid, nil
+		}
+		if !isFileNotExist(err) && !IsTrashed(err) {
+			index.Release(fid)
+			return "", err
+		}
+		//the indexed file is gone, drop it so the content is indexed again
+		if e := dropDedupEntry(index, fid); e != nil {
+			return "", c.wrapError(dedupErr(e))
+		}
+	}
+
+	fid, err = c.upload(b, group, ext, false, nil)
+	if err != nil {
+		return "", err
+	}
+	indexed, e := index.Put(key, fid)
+	if e != nil {
+		c.delete(fid)
+		return "", c.wrapError(dedupErr(e))
+	}
+	if indexed != fid {
+		//the same content is uploaded concurrently and indexed first
+		c.delete(fid)
+	}
+	return indexed, nil
+}
+
+// dropDedupEntry release all references of fid, so it is removed from index. References of other
+// callers are released too: their DedupDelete finds fid not indexed and deletes it directly, which
+// fails with file not exist as the file is already gone. The new upload is indexed with its own count.
+func dropDedupEntry(index DedupIndex, fid string) error {
+	for {
+		refs, err := index.Release(fid)
+		if err != nil {
+			return err
+		}
+		if refs <= 0 {
+			return nil
+		}
+	}
+}
+
+// DedupDelete remove a reference of the file uploaded by DedupUpload.
+//
+// DedupDelete is a wrapper of DefaultCluster.DedupDelete.
+func DedupDelete(fid string) error {
+	return DefaultCluster.DedupDelete(fid)
+}
+
+// DedupDelete remove a reference of the file uploaded by DedupUpload. The file is deleted when
+// the last reference is removed. A file not in the index is deleted directly.
+func (c *Cluster) DedupDelete(fid string) error {
+	index, err := c.dedupIndex()
+	if err != nil {
+		return err
+	}
+	refs, e := index.Release(fid)
+	if e != nil {
+		return c.wrapError(dedupErr(e))
+	}
+	if refs > 0 {
+		return nil
+	}
+	if err := c.Delete(fid); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileDedupIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "index", "dedup.json")
+	index, err := NewFileDedupIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "g1/5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03.txt"
+	fid := "g1/M00/00/00/CgIG6VuXIoeAbiwbAAAIIRe5FG4412.txt"
+	if got, err := index.Acquire(key); err != nil || got != "" {
+		t.Fatalf("acquire not indexed key, got %q, %v", got, err)
+	}
+	if got, err := index.Put(key, fid); err != nil || got != fid {
+		t.Fatalf("put key, got %q, %v", got, err)
+	}
+	if got, err := index.Put(key, "g1/M00/00/00/duplicate.txt"); err != nil || got != fid {
+		t.Fatalf("put indexed key, got %q, %v", got, err)
+	}
+
+	//references survive reopen
+	index, err = NewFileDedupIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := index.Acquire(key); err != nil || got != fid {
+		t.Fatalf("acquire reopened key, got %q, %v", got, err)
+	}
+	for want := 2; want >= 0; want-- {
+		if refs, err := index.Release(fid); err != nil || refs != want {
+			t.Fatalf("release fid, got %d, %v, want %d", refs, err, want)
+		}
+	}
+	if got, err := index.Acquire(key); err != nil || got != "" {
+		t.Fatalf("acquire released key, got %q, %v", got, err)
+	}
+	if refs, err := index.Release("g1/M00/00/00/not_indexed.txt"); err != nil || refs != 0 {
+		t.Fatalf("release not indexed fid, got %d, %v", refs, err)
+	}
+}
+
+func TestDropDedupEntry(t *testing.T) {
+	index := NewMemoryDedupIndex()
+	key := "g1/5891b5b522d5df08.txt"
+	fid := "g1/M00/00/00/CgIG6VuXIoeAbiwbAAAIIRe5FG4412.txt"
+	index.Put(key, fid)
+	index.Acquire(key)
+	index.Acquire(key)
+	if err := dropDedupEntry(index, fid); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := index.Acquire(key); got != "" {
+		t.Fatalf("dropped entry is still indexed, got %q", got)
+	}
+	newFid := "g1/M00/00/00/CgIG6VuXIoeAbiwbAAAIIRe5FG4413.txt"
+	if got, _ := index.Put(key, newFid); got != newFid {
+		t.Fatalf("put after drop, got %q", got)
+	}
+}
@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// DownloadOptions defines optional parameters of DownloadToFile.
+type DownloadOptions struct {
+	// ChunkSize is bytes requested by one download. Keep it far below StorageConfig.DownloadSizeLimit.
+	ChunkSize int64
+
+	// TempSuffix is appended to the destination path to name the temp file.
+	// Bytes in the temp file are kept after failure and used to resume next time.
+	TempSuffix string
+
+	// SkipCrc32 skip crc32 verify after download and only verify size.
+	// Set it for appender files whose crc32 may not be maintained by storage.
+	SkipCrc32 bool
+}
+
+var defaultDownloadOptions = DownloadOptions{
+	ChunkSize:  defaultChunkSize,
+	TempSuffix: ".fdfs.tmp",
+}
+
+// merge new options to old one.
+func (do *DownloadOptions) merge(new DownloadOptions) DownloadOptions {
+	result := *do
+	if new.ChunkSize > 0 {
+		result.ChunkSize = new.ChunkSize
+	}
+	if new.TempSuffix != "" {
+		result.TempSuffix = new.TempSuffix
+	}
+	result.SkipCrc32 = new.SkipCrc32
+	return result
+}
+
+// DownloadToFile download the whole file to local path.
+//
+// DownloadToFile is a wrapper of DefaultCluster.DownloadToFile.
+func DownloadToFile(ctx context.Context, fid, path string, opts DownloadOptions) error {
+	return DefaultCluster.DownloadToFile(ctx, fid, path, opts)
+}
+
+// DownloadToFile download the whole file to local path.
+//
+// File is downloaded chunk by chunk to a temp file next to path. If the temp file exists,
+// download resumes from its size. After all bytes are received, size and crc32 are verified
+// against file info query and the temp file is renamed to path. If verify fails the temp file
+// is removed, so the next call starts from zero.
+//
+// Context aborts the chunk in progress. Bytes are saved as stored, a compressed file is not decompressed.
+func (c *Cluster) DownloadToFile(ctx context.Context, fid, path string, opts DownloadOptions) error {
+	opts = defaultDownloadOptions.merge(opts)
+
+	info, err := c.queryFileInfo(fid)
+	if err != nil {
+		return err
+	}
+	read := func(offset, length int64) ([]byte, *Error) {
+		return c.downloadContext(ctx, fid, offset, length)
+	}
+	if err := c.downloadToFile(ctx, read, info, path, opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// downloadToFile save info.Size bytes got by read to path through the temp file
+func (c *Cluster) downloadToFile(ctx context.Context, read func(offset, length int64) ([]byte, *Error),
+	info *FileInfo, path string, opts DownloadOptions) *Error {
+	tmp := path + opts.TempSuffix
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return c.wrapError(localFileErr(err))
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	offset, err := c.resumeTempFile(f, h, info.Size)
+	if err != nil {
+		return c.wrapError(localFileErr(err))
+	}
+
+	for offset < info.Size {
+		if err := ctx.Err(); err != nil {
+			return c.wrapError(contextErr(err))
+		}
+		n := info.Size - offset
+		if n > opts.ChunkSize {
+			n = opts.ChunkSize
+		}
+		b, err := read(offset, n)
+		if err != nil {
+			return err
+		}
+		if len(b) == 0 {
+			return c.wrapError(unexpectedPkgLenErr(0, int(n)))
+		}
+		if _, err := f.Write(b); err != nil {
+			return c.wrapError(localFileErr(err))
+		}
+		h.Write(b)
+		offset += int64(len(b))
+	}
+
+	if err := f.Sync(); err != nil {
+		return c.wrapError(localFileErr(err))
+	}
+	if offset != info.Size || (!opts.SkipCrc32 && h.Sum32() != info.Crc32) {
+		f.Close()
+		os.Remove(tmp)
+		return c.wrapError(verifyFileErr(fmt.Errorf("local size %d crc32 %d, storage size %d crc32 %d",
+			offset, h.Sum32(), info.Size, info.Crc32)))
+	}
+	if err := f.Close(); err != nil {
+		return c.wrapError(localFileErr(err))
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return c.wrapError(localFileErr(err))
+	}
+	return nil
+}
+
+// resumeTempFile feed existing bytes of the temp file to hash and seek to its end.
+// A temp file larger than the remote file cannot be resumed and is truncated.
+func (c *Cluster) resumeTempFile(f *os.File, h hash.Hash32, size int64) (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if st.Size() > size {
+		if err := f.Truncate(0); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fileReader serves ranges of content and records offsets requested
+type fileReader struct {
+	content []byte
+	offsets []int64
+	extra   []byte
+}
+
+func (fr *fileReader) read(offset, length int64) ([]byte, *Error) {
+	fr.offsets = append(fr.offsets, offset)
+	end := offset + length
+	if end > int64(len(fr.content)) {
+		end = int64(len(fr.content))
+	}
+	b := append([]byte(nil), fr.content[offset:end]...)
+	if end == int64(len(fr.content)) {
+		b = append(b, fr.extra...)
+	}
+	return b, nil
+}
+
+func TestDownloadToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	info := &FileInfo{Size: int64(len(content)), Crc32: crc32.ChecksumIEEE(content)}
+	opts := defaultDownloadOptions.merge(DownloadOptions{ChunkSize: 300})
+	path := filepath.Join(dir, "a.txt")
+	tmp := path + opts.TempSuffix
+	c := New("test")
+
+	//resume from the temp file left by an interrupted download
+	if err := ioutil.WriteFile(tmp, content[:400], 0644); err != nil {
+		t.Fatal(err)
+	}
+	fr := &fileReader{content: content}
+	if err := c.downloadToFile(context.Background(), fr.read, info, path, opts); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); !bytes.Equal(b, content) {
+		t.Fatal("resumed file mismatch")
+	}
+	if len(fr.offsets) != 2 || fr.offsets[0] != 400 || fr.offsets[1] != 700 {
+		t.Fatalf("resume requested offsets %v, want [400 700]", fr.offsets)
+	}
+
+	//a temp file larger than the remote file starts over
+	if err := ioutil.WriteFile(tmp, append(content, 'x'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fr = &fileReader{content: content}
+	if err := c.downloadToFile(context.Background(), fr.read, info, path, opts); err != nil {
+		t.Fatal(err)
+	}
+	if fr.offsets[0] != 0 {
+		t.Fatalf("oversized temp file resumed from %d", fr.offsets[0])
+	}
+
+	//size mismatch removes the temp file
+	fr = &fileReader{content: content, extra: []byte("x")}
+	if err := c.downloadToFile(context.Background(), fr.read, info, path, opts); err == nil {
+		t.Fatal("size mismatch should fail")
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("temp file is kept after size mismatch")
+	}
+
+	//crc32 mismatch removes the temp file unless skipped
+	bad := &FileInfo{Size: info.Size, Crc32: info.Crc32 + 1}
+	fr = &fileReader{content: content}
+	if err := c.downloadToFile(context.Background(), fr.read, bad, path, opts); err == nil {
+		t.Fatal("crc32 mismatch should fail")
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("temp file is kept after crc32 mismatch")
+	}
+	opts.SkipCrc32 = true
+	if err := c.downloadToFile(context.Background(), fr.read, bad, path, opts); err != nil {
+		t.Fatalf("crc32 skipped, got %v", err)
+	}
+}
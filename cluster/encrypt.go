@@ -0,0 +1,332 @@
+package cluster
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// cipherAESGCM is the cipher of encrypted files, AES-256-GCM in segments
+	cipherAESGCM = "aes-256-gcm"
+
+	// metadata names of encrypted files
+	metaCipher      = "fdfs_cipher"
+	metaSegmentSize = "fdfs_segment_size"
+	metaKeyID       = "fdfs_key_id"
+	metaWrappedKey  = "fdfs_wrapped_key"
+
+	// encryptSegmentSize is plain bytes of each encrypted segment
+	encryptSegmentSize = 64 * 1024
+	// dataKeySize is bytes of per file data keys
+	dataKeySize = 32
+)
+
+// segmentCipher seals a file as segments, so any range can be decrypted by downloading
+// the segments covering it. Segment i is sealed with nonce i, and the last segment is
+// authenticated as the last, so segments cannot be reordered or truncated unnoticed.
+// Plain size and segment size are authenticated with every segment, so they cannot be
+// changed in metadata either.
+type segmentCipher struct {
+	aead cipher.AEAD
+	size int64
+}
+
+func newSegmentCipher(dataKey []byte, size int64) (*segmentCipher, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid segment size %d", size)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentCipher{aead: aead, size: size}, nil
+}
+
+// count return number of segments of plain size bytes. An empty file has one empty segment.
+func (sc *segmentCipher) count(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + sc.size - 1) / sc.size
+}
+
+// sealedOffset return offset of segment i in sealed file
+func (sc *segmentCipher) sealedOffset(i int64) int64 {
+	return i * (sc.size + int64(sc.aead.Overhead()))
+}
+
+// sealedEnd return end offset of segment i in sealed file of plain size bytes
+func (sc *segmentCipher) sealedEnd(i, size int64) int64 {
+	end := (i + 1) * sc.size
+	if end > size {
+		end = size
+	}
+	return end + (i+1)*int64(sc.aead.Overhead())
+}
+
+func (sc *segmentCipher) nonce(i int64) []byte {
+	nonce := make([]byte, sc.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(i))
+	return nonce
+}
+
+// additional return additional data of a segment of a file of plain size bytes
+func (sc *segmentCipher) additional(final bool, size int64) []byte {
+	ad := make([]byte, 17)
+	if final {
+		ad[0] = 1
+	}
+	binary.BigEndian.PutUint64(ad[1:], uint64(size))
+	binary.BigEndian.PutUint64(ad[9:], uint64(sc.size))
+	return ad
+}
+
+// seal all segments of b
+func (sc *segmentCipher) seal(b []byte) []byte {
+	size := int64(len(b))
+	n := sc.count(size)
+	sealed := make([]byte, 0, sc.sealedEnd(n-1, size))
+	for i := int64(0); i < n; i++ {
+		end := (i + 1) * sc.size
+		if end > size {
+			end = size
+		}
+		sealed = sc.aead.Seal(sealed, sc.nonce(i), b[i*sc.size:end], sc.additional(i == n-1, size))
+	}
+	return sealed
+}
+
+// open sealed segments starting from segment first of a file of plain size bytes
+func (sc *segmentCipher) open(sealed []byte, first, size int64) ([]byte, error) {
+	n := sc.count(size)
+	segment := int(sc.size) + sc.aead.Overhead()
+	plain := make([]byte, 0, len(sealed))
+	for i := first; len(sealed) > 0; i++ {
+		m := segment
+		if m > len(sealed) {
+			m = len(sealed)
+		}
+		var err error
+		if plain, err = sc.aead.Open(plain, sc.nonce(i), sealed[:m], sc.additional(i == n-1, size)); err != nil {
+			return nil, fmt.Errorf("segment %d: %s", i, err)
+		}
+		sealed = sealed[m:]
+	}
+	return plain, nil
+}
+
+// envelope is encryption metadata of a file
+type envelope struct {
+	size        int64
+	segmentSize int64
+	keyID       string
+	wrappedKey  []byte
+}
+
+func parseEnvelope(meta map[string]string) (*envelope, error) {
+	if meta[metaCipher] != cipherAESGCM {
+		return nil, fmt.Errorf("unsupported cipher %q", meta[metaCipher])
+	}
+	env := &envelope{keyID: meta[metaKeyID]}
+	var err error
+	if env.size, err = strconv.ParseInt(meta[metaRawSize], 10, 64); err != nil {
+		return nil, err
+	}
+	if env.segmentSize, err = strconv.ParseInt(meta[metaSegmentSize], 10, 64); err != nil {
+		return nil, err
+	}
+	if env.wrappedKey, err = base64.StdEncoding.DecodeString(meta[metaWrappedKey]); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Encryptor encrypts files at client side. Each file is encrypted by AES-256-GCM with its own
+// data key, which is wrapped by a master key of the key provider and stored in file metadata.
+// Storages never see plain content or usable keys.
+//
+// Rotating master keys only rewraps data keys by Rewrap, file bodies are not uploaded again.
+type Encryptor struct {
+	cluster *Cluster
+	keys    KeyProvider
+}
+
+// NewEncryptor create an encryptor of DefaultCluster.
+//
+// NewEncryptor is a wrapper of DefaultCluster.NewEncryptor.
+func NewEncryptor(keys KeyProvider) *Encryptor {
+	return DefaultCluster.NewEncryptor(keys)
+}
+
+// NewEncryptor create an encryptor wrapping data keys by keys.
+func (c *Cluster) NewEncryptor(keys KeyProvider) *Encryptor {
+	return &Encryptor{cluster: c, keys: keys}
+}
+
+// Upload encrypt b and upload it to the group with specified extension name.
+func (en *Encryptor) Upload(b []byte, group, ext string) (string, error) {
+	fid, err := en.upload(b, group, ext)
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
+}
+
+func (en *Encryptor) upload(b []byte, group, ext string) (string, *Error) {
+	c := en.cluster
+	sealed, meta, e := en.seal(b, encryptSegmentSize)
+	if e != nil {
+		return "", c.wrapError(encryptErr(e))
+	}
+	fid, err := c.upload(sealed, group, ext, false, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := c.setMetadata(fid, meta, STORAGE_SET_METADATA_FLAG_MERGE); err != nil {
+		//without the data key the file cannot be decrypted
+		c.delete(fid)
+		return "", err
+	}
+	return fid, nil
+}
+
+// seal b with a new data key in segments of segmentSize bytes and return its metadata
+func (en *Encryptor) seal(b []byte, segmentSize int64) ([]byte, map[string]string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	keyID, wrapped, err := en.keys.WrapKey(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc, err := newSegmentCipher(dataKey, segmentSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := map[string]string{
+		metaCipher:      cipherAESGCM,
+		metaRawSize:     strconv.Itoa(len(b)),
+		metaSegmentSize: strconv.FormatInt(segmentSize, 10),
+		metaKeyID:       keyID,
+		metaWrappedKey:  base64.StdEncoding.EncodeToString(wrapped),
+	}
+	return sc.seal(b), meta, nil
+}
+
+// open return envelope and cipher of the encrypted file
+func (en *Encryptor) open(fid string) (*envelope, *segmentCipher, *Error) {
+	meta, err := en.cluster.getMetadata(fid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return en.openMeta(meta)
+}
+
+// openMeta return envelope and cipher of the encrypted file of metadata meta
+func (en *Encryptor) openMeta(meta map[string]string) (*envelope, *segmentCipher, *Error) {
+	c := en.cluster
+	env, e := parseEnvelope(meta)
+	if e != nil {
+		return nil, nil, c.wrapError(encryptErr(e))
+	}
+	dataKey, e := en.keys.UnwrapKey(env.keyID, env.wrappedKey)
+	if e != nil {
+		return nil, nil, c.wrapError(encryptErr(e))
+	}
+	sc, e := newSegmentCipher(dataKey, env.segmentSize)
+	if e != nil {
+		return nil, nil, c.wrapError(encryptErr(e))
+	}
+	return env, sc, nil
+}
+
+// Download and decrypt the whole file.
+func (en *Encryptor) Download(fid string) ([]byte, error) {
+	return en.DownloadFromOffset(fid, 0, 0)
+}
+
+// DownloadFromOffset download and decrypt length bytes from offset of plain content.
+// If length is 0, it reads to the end. Only segments covering the range are downloaded.
+func (en *Encryptor) DownloadFromOffset(fid string, offset, length int64) ([]byte, error) {
+	b, err := en.downloadFromOffset(fid, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (en *Encryptor) downloadFromOffset(fid string, offset, length int64) ([]byte, *Error) {
+	env, sc, err := en.open(fid)
+	if err != nil {
+		return nil, err
+	}
+	return en.readRange(env, sc, offset, length, func(from, length int64) ([]byte, *Error) {
+		return en.cluster.DownloadFromOffset(fid, from, length)
+	})
+}
+
+// readRange decrypt length bytes from offset of plain content, downloading sealed bytes by download
+func (en *Encryptor) readRange(env *envelope, sc *segmentCipher, offset, length int64,
+	download func(from, length int64) ([]byte, *Error)) ([]byte, *Error) {
+	c := en.cluster
+	if offset < 0 || offset > env.size || length < 0 {
+		return nil, c.wrapError(encryptErr(fmt.Errorf("range %d+%d out of %d bytes", offset, length, env.size)))
+	}
+	end := env.size
+	if length > 0 && offset+length < end {
+		end = offset + length
+	}
+	if offset == end {
+		return []byte{}, nil
+	}
+
+	first, last := offset/sc.size, (end-1)/sc.size
+	from, to := sc.sealedOffset(first), sc.sealedEnd(last, env.size)
+	sealed, err := download(from, to-from)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(sealed)) != to-from {
+		return nil, c.wrapError(encryptErr(fmt.Errorf("downloaded %d bytes, want %d", len(sealed), to-from)))
+	}
+	plain, e := sc.open(sealed, first, env.size)
+	if e != nil {
+		return nil, c.wrapError(encryptErr(e))
+	}
+	return plain[offset-first*sc.size : end-first*sc.size], nil
+}
+
+// Rewrap unwrap the data key of the file and wrap it again by the current master key of
+// the key provider. The file body is not changed.
+func (en *Encryptor) Rewrap(fid string) error {
+	c := en.cluster
+	meta, err := c.getMetadata(fid)
+	if err != nil {
+		return err
+	}
+	env, e := parseEnvelope(meta)
+	if e != nil {
+		return c.wrapError(encryptErr(e))
+	}
+	dataKey, e := en.keys.UnwrapKey(env.keyID, env.wrappedKey)
+	if e != nil {
+		return c.wrapError(encryptErr(e))
+	}
+	keyID, wrapped, e := en.keys.WrapKey(dataKey)
+	if e != nil {
+		return c.wrapError(encryptErr(e))
+	}
+	meta = map[string]string{
+		metaKeyID:      keyID,
+		metaWrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+	}
+	if err := c.setMetadata(fid, meta, STORAGE_SET_METADATA_FLAG_MERGE); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSegmentCipher(t *testing.T) {
+	sc, err := newSegmentCipher(bytes.Repeat([]byte{7}, dataKeySize), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int64{0, 1, 100, 250} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		sealed := sc.seal(plain)
+		if int64(len(sealed)) != sc.sealedEnd(sc.count(size)-1, size) {
+			t.Fatalf("seal %d bytes, got %d sealed bytes", size, len(sealed))
+		}
+		got, err := sc.open(sealed, 0, size)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("open %d bytes, got %d bytes, %v", size, len(got), err)
+		}
+	}
+
+	plain := make([]byte, 250)
+	rand.Read(plain)
+	sealed := sc.seal(plain)
+	//the second and the last segment
+	got, err := sc.open(sealed[sc.sealedOffset(1):], 1, 250)
+	if err != nil || !bytes.Equal(got, plain[100:]) {
+		t.Fatalf("open from segment 1, got %d bytes, %v", len(got), err)
+	}
+	//truncated at segment boundary
+	if _, err := sc.open(sealed[:sc.sealedEnd(1, 250)], 0, 200); err == nil {
+		t.Fatal("open truncated file should fail")
+	}
+	//segments swapped
+	swapped := append(append([]byte(nil), sealed[sc.sealedOffset(1):sc.sealedEnd(1, 250)]...), sealed[:sc.sealedOffset(1)]...)
+	if _, err := sc.open(swapped, 0, 250); err == nil {
+		t.Fatal("open swapped segments should fail")
+	}
+}
+
+func TestFileKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	path := filepath.Join(dir, "keyring.json")
+	if err := ioutil.WriteFile(path, []byte(`{"current":"k1","keys":{"k1":"`+key1+`"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kr, err := NewFileKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey := bytes.Repeat([]byte{9}, dataKeySize)
+	id, wrapped, err := kr.WrapKey(dataKey)
+	if err != nil || id != "k1" {
+		t.Fatalf("wrap key, got %q, %v", id, err)
+	}
+
+	//rotate to k2
+	if err := ioutil.WriteFile(path, []byte(`{"current":"k2","keys":{"k1":"`+key1+`","k2":"`+key2+`"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := kr.UnwrapKey(id, wrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap by old key, got %v, %v", got, err)
+	}
+	if id, _, _ := kr.WrapKey(dataKey); id != "k2" {
+		t.Fatalf("wrap key after rotation, got %q", id)
+	}
+	if _, err := kr.UnwrapKey("k2", wrapped); err == nil {
+		t.Fatal("unwrap by another key should fail")
+	}
+}
+
+func TestEncryptorReadRange(t *testing.T) {
+	keys, err := NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStaticKeyProvider("k1", []byte("short")); err == nil {
+		t.Error("master key of 5 bytes should be rejected")
+	}
+	en := New("test").NewEncryptor(keys)
+	plain := make([]byte, 250)
+	rand.Read(plain)
+	sealed, meta, err := en.seal(plain, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the fake download serves ranges of the sealed file and records them
+	var ranges [][2]int64
+	download := func(from, length int64) ([]byte, *Error) {
+		ranges = append(ranges, [2]int64{from, length})
+		return sealed[from : from+length], nil
+	}
+	env, sc, e := en.openMeta(meta)
+	if e != nil {
+		t.Fatal(e)
+	}
+	overhead := int64(sc.aead.Overhead())
+	for _, c := range []struct {
+		offset, length int64
+		from, to       int64
+	}{
+		{0, 0, 0, 250 + 3*overhead},
+		{0, 100, 0, 100 + overhead},
+		{99, 2, 0, 200 + 2*overhead},
+		{150, 10, 100 + overhead, 200 + 2*overhead},
+		{200, 0, 200 + 2*overhead, 250 + 3*overhead},
+		{240, 100, 200 + 2*overhead, 250 + 3*overhead},
+	} {
+		ranges = nil
+		got, err := en.readRange(env, sc, c.offset, c.length, download)
+		end := int64(250)
+		if c.length > 0 && c.offset+c.length < end {
+			end = c.offset + c.length
+		}
+		if err != nil || !bytes.Equal(got, plain[c.offset:end]) {
+			t.Fatalf("read %d+%d, got %d bytes, %v", c.offset, c.length, len(got), err)
+		}
+		if len(ranges) != 1 || ranges[0][0] != c.from || ranges[0][0]+ranges[0][1] != c.to {
+			t.Fatalf("read %d+%d downloaded %v, want %d-%d", c.offset, c.length, ranges, c.from, c.to)
+		}
+	}
+	if _, err := en.readRange(env, sc, 251, 0, download); err == nil {
+		t.Error("read beyond the end should fail")
+	}
+
+	//a plain size lowered in metadata is detected
+	meta[metaRawSize] = "220"
+	env, sc, e = en.openMeta(meta)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, err := en.readRange(env, sc, 0, 0, download); err == nil {
+		t.Error("read with plain size changed in metadata should fail")
+	}
+}
@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ErasureManifest records how an object is erasure coded and where its shards are.
+// It is small enough to be saved by caller or uploaded as a file by SaveErasureManifest.
+type ErasureManifest struct {
+	// Size is object size in bytes
+	Size int64 `json:"size"`
+
+	// DataShards and ParityShards are number of shards. Any DataShards of them reconstruct the object.
+	DataShards   int `json:"k"`
+	ParityShards int `json:"m"`
+
+	// Shards are fids of data shards followed by parity shards, each in a different group
+	Shards []string `json:"shards"`
+}
+
+// UploadErasure split b into data and parity shards and upload each shard to a different group.
+//
+// UploadErasure is a wrapper of DefaultCluster.UploadErasure.
+func UploadErasure(b []byte, groups []string, dataShards, parityShards int, ext string) (*ErasureManifest, error) {
+	return DefaultCluster.UploadErasure(b, groups, dataShards, parityShards, ext)
+}
+
+// UploadErasure split b into dataShards data shards, compute parityShards Reed-Solomon parity shards
+// and upload shard i to groups[i] concurrently. The object survives loss of any parityShards groups
+// at a storage cost of (dataShards+parityShards)/dataShards, far less than full copies.
+//
+// If any shard fails to upload, the uploaded ones are deleted and error is returned.
+func (c *Cluster) UploadErasure(b []byte, groups []string, dataShards, parityShards int, ext string) (*ErasureManifest, error) {
+	rs, e := newReedSolomon(dataShards, parityShards)
+	if e != nil {
+		return nil, c.wrapError(erasureErr(e))
+	}
+	if len(groups) < dataShards+parityShards {
+		return nil, c.wrapError(erasureErr(fmt.Errorf("%d groups for %d shards", len(groups), dataShards+parityShards)))
+	}
+	if group := duplicateGroup(groups[:dataShards+parityShards]); group != "" {
+		//shards in the same group would be lost together
+		return nil, c.wrapError(erasureErr(fmt.Errorf("group %s is given for more than one shard", group)))
+	}
+
+	//pad the object to equal shards
+	shardSize := (len(b) + dataShards - 1) / dataShards
+	padded := make([]byte, shardSize*dataShards)
+	copy(padded, b)
+	shards := make([][]byte, dataShards, dataShards+parityShards)
+	for i := range shards {
+		shards[i] = padded[i*shardSize : (i+1)*shardSize]
+	}
+	shards = append(shards, rs.encode(shards)...)
+
+	m := &ErasureManifest{
+		Size:         int64(len(b)),
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+		Shards:       make([]string, len(shards)),
+	}
+	errs := make([]*Error, len(shards))
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.Shards[i], errs[i] = c.upload(shards[i], groups[i], ext, false, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			//shards never returned to caller bypass trash
+			for _, fid := range m.Shards {
+				if fid != "" {
+					c.delete(fid)
+				}
+			}
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// DownloadErasure download the erasure coded object.
+//
+// DownloadErasure is a wrapper of DefaultCluster.DownloadErasure.
+func DownloadErasure(m *ErasureManifest) ([]byte, error) {
+	return DefaultCluster.DownloadErasure(m)
+}
+
+// DownloadErasure download the erasure coded object. Data shards are downloaded concurrently.
+// For each of them failed or of wrong size, a parity shard is downloaded instead, and the object
+// is reconstructed from any DataShards shards.
+func (c *Cluster) DownloadErasure(m *ErasureManifest) ([]byte, error) {
+	rs, e := newReedSolomon(m.DataShards, m.ParityShards)
+	if e != nil {
+		return nil, c.wrapError(erasureErr(e))
+	}
+	if len(m.Shards) != m.DataShards+m.ParityShards {
+		return nil, c.wrapError(erasureErr(fmt.Errorf("%d shards in manifest, want %d", len(m.Shards), m.DataShards+m.ParityShards)))
+	}
+
+	shardSize := int((m.Size + int64(m.DataShards) - 1) / int64(m.DataShards))
+	shards := make([][]byte, len(m.Shards))
+	errs := make([]*Error, len(m.Shards))
+	next, have := 0, 0
+	for have < m.DataShards && next < len(shards) {
+		//download as many shards as still needed
+		batch := m.DataShards - have
+		if batch > len(shards)-next {
+			batch = len(shards) - next
+		}
+		var wg sync.WaitGroup
+		for i := next; i < next+batch; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				shards[i], errs[i] = c.DownloadFromOffset(m.Shards[i], 0, 0)
+				if errs[i] == nil && len(shards[i]) != shardSize {
+					//a truncated or wrong shard is missing
+					errs[i] = c.wrapError(erasureErr(fmt.Errorf("shard %d has %d bytes, want %d", i, len(shards[i]), shardSize)))
+				}
+			}(i)
+		}
+		wg.Wait()
+		for i := next; i < next+batch; i++ {
+			if errs[i] == nil {
+				have++
+			} else {
+				shards[i] = nil
+			}
+		}
+		next += batch
+	}
+	if have < m.DataShards {
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if e := rs.reconstructData(shards); e != nil {
+		return nil, c.wrapError(erasureErr(e))
+	}
+	b := make([]byte, 0, m.Size)
+	for _, s := range shards[:m.DataShards] {
+		b = append(b, s...)
+	}
+	if int64(len(b)) < m.Size {
+		return nil, c.wrapError(erasureErr(fmt.Errorf("reconstructed %d bytes, want %d", len(b), m.Size)))
+	}
+	return b[:m.Size], nil
+}
+
+// DeleteErasure delete all shards of the object.
+//
+// DeleteErasure is a wrapper of DefaultCluster.DeleteErasure.
+func DeleteErasure(m *ErasureManifest) error {
+	return DefaultCluster.DeleteErasure(m)
+}
+
+// DeleteErasure delete all shards of the object. A shard already gone is not an error,
+// the others are still deleted if one fails and the first error is returned.
+func (c *Cluster) DeleteErasure(m *ErasureManifest) error {
+	var first *Error
+	for _, fid := range m.Shards {
+		if fid == "" {
+			continue
+		}
+		if err := c.Delete(fid); err != nil && !isFileNotExist(err) && first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return nil
+}
+
+// SaveErasureManifest upload the manifest to the group as a file and return its fid.
+//
+// SaveErasureManifest is a wrapper of DefaultCluster.SaveErasureManifest.
+func SaveErasureManifest(m *ErasureManifest, group string) (string, error) {
+	return DefaultCluster.SaveErasureManifest(m, group)
+}
+
+// SaveErasureManifest upload the manifest to the group as a file and return its fid.
+// Choose a group other than those of shards, or keep a copy by caller as well.
+func (c *Cluster) SaveErasureManifest(m *ErasureManifest, group string) (string, error) {
+	fid, err := c.uploadManifest(m, group)
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
+}
+
+// LoadErasureManifest download the manifest file saved by SaveErasureManifest.
+//
+// LoadErasureManifest is a wrapper of DefaultCluster.LoadErasureManifest.
+func LoadErasureManifest(fid string) (*ErasureManifest, error) {
+	return DefaultCluster.LoadErasureManifest(fid)
+}
+
+// LoadErasureManifest download the manifest file saved by SaveErasureManifest.
+func (c *Cluster) LoadErasureManifest(fid string) (*ErasureManifest, error) {
+	m := &ErasureManifest{}
+	if err := c.downloadManifest(fid, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -1,6 +1,13 @@
 package cluster
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
 
 type Error struct {
 	name   string
@@ -32,10 +39,138 @@ func getConnErr(err error) *Error {
 	return NewError("GetConnFromPoolErr", err)
 }
 
+// isConnFailure return true if err is failure to reach the storage, before any response is read.
+// A connection reset or timeout reading the response header is one too, unlike a status code error.
+func isConnFailure(err *Error) bool {
+	if err == nil {
+		return false
+	}
+	for _, name := range []string{"CreatePoolErr", "GetConnFromPoolErr", "WriteRequestHeaderErr"} {
+		if strings.HasSuffix(err.name, name) {
+			return true
+		}
+	}
+	if strings.HasSuffix(err.name, "ReadResponseHeaderErr") {
+		if _, ok := err.detail.(net.Error); ok {
+			return true
+		}
+		return err.detail == io.EOF || err.detail == io.ErrUnexpectedEOF
+	}
+	return false
+}
+
 func unexpectedPkgLenErr(receive, expect int) *Error {
 	return NewError(" UnexpectedLenErr", fmt.Errorf("received pkg length %d != expected %d", receive, expect))
 }
 
+func sizeLimitErr(size, limit int64) *Error {
+	return NewError("SizeLimitErr", fmt.Errorf("%d bytes exceed download size limit %d", size, limit))
+}
+
 func wrongFidErr(fid string) *Error {
 	return NewError("WrongFidErr", fmt.Errorf("fid is not with format group/filename: %s", fid))
 }
+
+func checkpointErr(err error) *Error {
+	return NewError("CheckpointErr", err)
+}
+
+func readSourceErr(err error) *Error {
+	return NewError("ReadSourceErr", err)
+}
+
+func contextErr(err error) *Error {
+	return NewError("ContextErr", err)
+}
+
+// isContextErr return true if err is caused by a done context
+func isContextErr(err *Error) bool {
+	return err != nil && (err.detail == context.Canceled || err.detail == context.DeadlineExceeded)
+}
+
+func localFileErr(err error) *Error {
+	return NewError("LocalFileErr", err)
+}
+
+func verifyFileErr(err error) *Error {
+	return NewError("VerifyFileErr", err)
+}
+
+func decodeFidErr(err error) *Error {
+	return NewError("DecodeFidErr", err)
+}
+
+func cacheErr(err error) *Error {
+	return NewError("CacheErr", err)
+}
+
+func placementErr(err error) *Error {
+	return NewError("PlacementErr", err)
+}
+
+func replicateErr(err error) *Error {
+	return NewError("ReplicateErr", err)
+}
+
+// isFileNotExist return true if storage responds the file does not exist
+func isFileNotExist(err *Error) bool {
+	return err != nil && err.detail == errFileNotExist
+}
+
+func erasureErr(err error) *Error {
+	return NewError("ErasureErr", err)
+}
+
+func manifestErr(err error) *Error {
+	return NewError("ManifestErr", err)
+}
+
+func chunkErr(err error) *Error {
+	return NewError("ChunkErr", err)
+}
+
+func dedupErr(err error) *Error {
+	return NewError("DedupErr", err)
+}
+
+func codecErr(err error) *Error {
+	return NewError("CodecErr", err)
+}
+
+func encryptErr(err error) *Error {
+	return NewError("EncryptErr", err)
+}
+
+func expiryErr(err error) *Error {
+	return NewError("ExpiryErr", err)
+}
+
+// ErrTrashed is detail of errors reading a file in trash
+var ErrTrashed = errors.New("file is in trash")
+
+// IsTrashed return true if err is caused by reading a file in trash.
+func IsTrashed(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e != nil && e.detail == ErrTrashed
+}
+
+func trashedErr() *Error {
+	return NewError("TrashedErr", ErrTrashed)
+}
+
+// isRestored return true if err is the purge error of a file restored from trash
+func isRestored(err *Error) bool {
+	return err != nil && err.detail == errRestored
+}
+
+func trashErr(err error) *Error {
+	return NewError("TrashErr", err)
+}
+
+func idempotencyErr(err error) *Error {
+	return NewError("IdempotencyErr", err)
+}
+
+func txErr(err error) *Error {
+	return NewError("TxErr", err)
+}
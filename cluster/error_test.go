@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestIsConnFailure(t *testing.T) {
+	cases := []struct {
+		err  *Error
+		fail bool
+	}{
+		{nil, false},
+		{createPoolErr(errors.New("dial")), true},
+		{NewError("WriteRequestHeaderErr", io.ErrClosedPipe).Wrap("test"), true},
+		{NewError("ReadResponseHeaderErr", io.EOF), true},
+		{NewError("ReadResponseHeaderErr", timeoutErr{}), true},
+		{NewError("ReadResponseHeaderErr", errFileNotExist), false},
+		{NewError("ReadResponseHeaderErr", errors.New("status code 22")), false},
+		{NewError("ReadResponseBodyErr", io.ErrUnexpectedEOF), false},
+	}
+	for i, c := range cases {
+		if isConnFailure(c.err) != c.fail {
+			t.Errorf("case %d: %v conn failure should be %v", i, c.err, c.fail)
+		}
+	}
+}
+
+func TestDownloadFromSource(t *testing.T) {
+	//source storage and the storage tracker chose listen on the same port
+	src, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer src.Close()
+	_, port, _ := net.SplitHostPort(src.Addr().String())
+	chosen, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer chosen.Close()
+
+	fields := make([]byte, 20)
+	copy(fields, []byte{127, 0, 0, 2})
+	binary.BigEndian.PutUint32(fields[4:], uint32(time.Now().Unix()))
+	fid := "group1/M00/00/00/" + base64.RawURLEncoding.EncodeToString(fields) + ".jpg"
+
+	c := New("test")
+	c.SetReadAfterWrite(time.Hour)
+	c.routes.update(time.Hour, 10)
+
+	cases := []struct {
+		srcErr   *Error
+		err      bool
+		fallback bool
+	}{
+		{nil, false, false},
+		{NewError("ReadResponseHeaderErr", errFileNotExist), false, true},
+		{NewError("ReadResponseHeaderErr", io.EOF), false, true},
+		{NewError("ReadResponseHeaderErr", errors.New("status code 22")), true, false},
+		{NewError("ReadResponseBodyErr", io.ErrUnexpectedEOF), true, false},
+	}
+	for i, cs := range cases {
+		c.routes.setDownloadStorage(fid, &TrackerStoreInfo{Address: chosen.Addr().String(), Group: "group1"})
+		var addresses []string
+		err := c.downloadFrom(fid, func(s *Storage, filename string) *Error {
+			addresses = append(addresses, s.address)
+			if s.address == src.Addr().String() {
+				return cs.srcErr
+			}
+			return nil
+		})
+		if (err != nil) != cs.err || len(addresses) == 0 || addresses[0] != src.Addr().String() {
+			t.Errorf("case %d: %v, storages %v", i, err, addresses)
+		}
+		if (len(addresses) == 2) != cs.fallback {
+			t.Errorf("case %d: fallback should be %v, storages %v", i, cs.fallback, addresses)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// metaExpire is metadata name of unix time a file expires at
+	metaExpire = "fdfs_expire"
+
+	// defaults of ExpiryConfig
+	defaultSweepInterval   = time.Minute
+	defaultSweepBatch      = 1000
+	defaultSweepRate       = 100
+	defaultSweepRetries    = 2
+	defaultSweepRetryDelay = time.Second
+)
+
+// ExpiryStore registers files with expiry time. Implementations must be safe for concurrent use.
+type ExpiryStore interface {
+	// Add register the file expiring at expire.
+	Add(fid string, expire time.Time) error
+
+	// Expired return at most limit fids expired at now, the earliest first.
+	Expired(now time.Time, limit int) ([]string, error)
+
+	// Remove the file from registry. Removing a file not registered is not an error.
+	Remove(fid string) error
+}
+
+// FileExpiryStore keeps the registry in memory and saves it to a json file on every change.
+// It suits registries of moderate size, use a database backed ExpiryStore for large ones.
+// It is a TrashStore as well, use a different file for trash.
+type FileExpiryStore struct {
+	path string
+
+	// expires maps fid to unix time it expires at
+	expires map[string]int64
+
+	mtx sync.Mutex
+}
+
+// NewFileExpiryStore load registry from the file, an empty registry is created if the file not exist.
+func NewFileExpiryStore(path string) (*FileExpiryStore, error) {
+	fs := &FileExpiryStore{path: path, expires: make(map[string]int64)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fs, os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &fs.expires); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Add register the file expiring at expire.
+func (fs *FileExpiryStore) Add(fid string, expire time.Time) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	old, ok := fs.expires[fid]
+	fs.expires[fid] = expire.Unix()
+	if err := fs.save(); err != nil {
+		if ok {
+			fs.expires[fid] = old
+		} else {
+			delete(fs.expires, fid)
+		}
+		return err
+	}
+	return nil
+}
+
+// Expired return at most limit fids expired at now, the earliest first.
+func (fs *FileExpiryStore) Expired(now time.Time, limit int) ([]string, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	var fids []string
+	for fid, expire := range fs.expires {
+		if expire <= now.Unix() {
+			fids = append(fids, fid)
+		}
+	}
+	sort.Slice(fids, func(i, j int) bool {
+		return fs.expires[fids[i]] < fs.expires[fids[j]]
+	})
+	if len(fids) > limit {
+		fids = fids[:limit]
+	}
+	return fids, nil
+}
+
+// Contains return true if the file is registered.
+func (fs *FileExpiryStore) Contains(fid string) (bool, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	_, ok := fs.expires[fid]
+	return ok, nil
+}
+
+// Remove the file from registry.
+func (fs *FileExpiryStore) Remove(fid string) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	expire, ok := fs.expires[fid]
+	if !ok {
+		return nil
+	}
+	delete(fs.expires, fid)
+	if err := fs.save(); err != nil {
+		fs.expires[fid] = expire
+		return err
+	}
+	return nil
+}
+
+// save write the registry to file atomically
+func (fs *FileExpiryStore) save() error {
+	b, err := json.Marshal(fs.expires)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.path, b)
+}
+
+// ExpiryConfig defines the registry of files uploaded by UploadWithTTL and the sweeper deleting them.
+type ExpiryConfig struct {
+	// Store is the registry of files with expiry time, nil to disable UploadWithTTL and the sweeper.
+	Store ExpiryStore
+
+	// Interval between two sweeps. Default 1 minute.
+	Interval time.Duration
+
+	// Rate is max files deleted per second. Default 100.
+	Rate int
+
+	// Retries is times a failed delete is retried in a sweep. Default 2, negative for no retry.
+	// A file still failing is kept in registry with expiry postponed by Interval, so it does not
+	// hold back files expired later.
+	Retries int
+
+	// RetryDelay is time to wait before retrying a delete. Default 1 second.
+	RetryDelay time.Duration
+
+	// Report is called with the report of each background sweep if not nil.
+	Report func(*SweepReport)
+}
+
+// SweepReport is the result of a sweep.
+type SweepReport struct {
+	// Start and End time of the sweep
+	Start, End time.Time
+
+	// Deleted are fids deleted, or already gone or in trash, and removed from registry
+	Deleted []string
+
+	// Failed are fids failed to delete with the last error. They are kept in registry and postponed.
+	Failed map[string]error
+
+	// Err is error of the registry if any. The sweep stops on registry errors.
+	Err error
+}
+
+// sweeper deletes expired files in background
+type sweeper struct {
+	config  ExpiryConfig
+	limiter *rateLimiter
+
+	// delete a file, retried on failure
+	delete func(fid string) *Error
+
+	// postpone the expiry of a file failed to delete
+	postpone func(fid string, expire time.Time) error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newSweeper(config ExpiryConfig, delete func(fid string) *Error) *sweeper {
+	if config.Interval <= 0 {
+		config.Interval = defaultSweepInterval
+	}
+	if config.Rate <= 0 {
+		config.Rate = defaultSweepRate
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	} else if config.Retries == 0 {
+		config.Retries = defaultSweepRetries
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = defaultSweepRetryDelay
+	}
+	return &sweeper{
+		config:   config,
+		limiter:  newRateLimiter(int64(config.Rate)),
+		delete:   delete,
+		postpone: config.Store.Add,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// run sweep every interval until stopped
+func (sw *sweeper) run() {
+	defer close(sw.done)
+
+	ticker := time.NewTicker(sw.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report := sw.sweep()
+			if sw.config.Report != nil {
+				sw.config.Report(report)
+			}
+		case <-sw.stop:
+			return
+		}
+	}
+}
+
+// close stop the sweeper and wait for the sweep in progress
+func (sw *sweeper) close() {
+	close(sw.stop)
+	<-sw.done
+}
+
+// sweep delete files expired now. A sweep in progress stops between files if the sweeper is closed.
+func (sw *sweeper) sweep() *SweepReport {
+	report := &SweepReport{Start: time.Now(), Failed: make(map[string]error)}
+	defer func() { report.End = time.Now() }()
+
+	fids, err := sw.config.Store.Expired(report.Start, defaultSweepBatch)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	for _, fid := range fids {
+		select {
+		case <-sw.stop:
+			return report
+		default:
+		}
+		sw.limiter.wait(1)
+		err := sw.deleteWithRetry(fid)
+		if isRestored(err) {
+			continue
+		}
+		if err != nil {
+			report.Failed[fid] = err
+			if e := sw.postpone(fid, report.Start.Add(sw.config.Interval)); e != nil {
+				report.Err = e
+				return report
+			}
+			continue
+		}
+		if err := sw.config.Store.Remove(fid); err != nil {
+			report.Err = err
+			return report
+		}
+		report.Deleted = append(report.Deleted, fid)
+	}
+	return report
+}
+
+// deleteWithRetry delete the file with retries. A file already gone or in trash is deleted.
+// It returns the last error without retrying more if the sweeper is closed.
+func (sw *sweeper) deleteWithRetry(fid string) *Error {
+	var err *Error
+	for i := 0; i <= sw.config.Retries; i++ {
+		if i > 0 {
+			timer := time.NewTimer(sw.config.RetryDelay)
+			select {
+			case <-timer.C:
+			case <-sw.stop:
+				timer.Stop()
+				return err
+			}
+		}
+		if err = sw.delete(fid); err == nil || isFileNotExist(err) || IsTrashed(err) {
+			return nil
+		}
+		if isRestored(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// SetExpiry set the registry of UploadWithTTL and start the sweeper.
+//
+// SetExpiry is a wrapper of DefaultCluster.SetExpiry.
+func SetExpiry(config ExpiryConfig) {
+	DefaultCluster.SetExpiry(config)
+}
+
+// SetExpiry set the registry of UploadWithTTL and start a background sweeper deleting expired
+// files every interval. The sweeper started before is stopped. A nil Store stops the sweeper only.
+func (c *Cluster) SetExpiry(config ExpiryConfig) {
+	var sw *sweeper
+	if config.Store != nil {
+		sw = newSweeper(config, c.Delete)
+	}
+
+	c.mtx.Lock()
+	old := c.sweeper
+	c.sweeper = sw
+	c.mtx.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	if sw != nil {
+		go sw.run()
+	}
+}
+
+func (c *Cluster) expirySweeper() (*sweeper, *Error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.sweeper == nil {
+		return nil, c.wrapError(expiryErr(errors.New("expiry store is not set")))
+	}
+	return c.sweeper, nil
+}
+
+// UploadWithTTL upload a file deleted by the sweeper after ttl.
+//
+// UploadWithTTL is a wrapper of DefaultCluster.UploadWithTTL.
+func UploadWithTTL(b []byte, group, ext string, ttl time.Duration) (string, error) {
+	return DefaultCluster.UploadWithTTL(b, group, ext, ttl)
+}
+
+// UploadWithTTL upload a file to the group with specified extension name, which is deleted by
+// the sweeper after ttl. The expiry is recorded in file metadata and the registry set by SetExpiry.
+func (c *Cluster) UploadWithTTL(b []byte, group, ext string, ttl time.Duration) (string, error) {
+	sw, err := c.expirySweeper()
+	if err != nil {
+		return "", err
+	}
+	expire := time.Now().Add(ttl)
+	fid, err := c.upload(b, group, ext, false, nil)
+	if err != nil {
+		return "", err
+	}
+	meta := map[string]string{metaExpire: strconv.FormatInt(expire.Unix(), 10)}
+	if err := c.setMetadata(fid, meta, STORAGE_SET_METADATA_FLAG_MERGE); err != nil {
+		c.delete(fid)
+		return "", err
+	}
+	if e := sw.config.Store.Add(fid, expire); e != nil {
+		//a file not registered would never be deleted
+		c.delete(fid)
+		return "", c.wrapError(expiryErr(e))
+	}
+	return fid, nil
+}
+
+// SweepExpired delete expired files now.
+//
+// SweepExpired is a wrapper of DefaultCluster.SweepExpired.
+func SweepExpired() (*SweepReport, error) {
+	return DefaultCluster.SweepExpired()
+}
+
+// SweepExpired delete expired files now, in addition to the background sweeps.
+// The rate limit is shared with the background sweeper.
+func (c *Cluster) SweepExpired() (*SweepReport, error) {
+	sw, err := c.expirySweeper()
+	if err != nil {
+		return nil, err
+	}
+	return sw.sweep(), nil
+}
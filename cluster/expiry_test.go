@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestExpiryStore(t *testing.T) *FileExpiryStore {
+	dir, err := ioutil.TempDir("", "expiry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileExpiryStore(filepath.Join(dir, "expiry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSweepPostponesFailed(t *testing.T) {
+	store := newTestExpiryStore(t)
+	defer os.RemoveAll(filepath.Dir(store.path))
+
+	now := time.Now()
+	store.Add("failed", now.Add(-2*time.Second))
+	store.Add("deleted", now.Add(-time.Second))
+
+	var deleted []string
+	sw := newSweeper(ExpiryConfig{Store: store, Interval: time.Hour, Retries: -1}, func(fid string) *Error {
+		if fid == "failed" {
+			return NewError("Delete", errors.New("unavailable"))
+		}
+		deleted = append(deleted, fid)
+		return nil
+	})
+
+	report := sw.sweep()
+	if report.Err != nil || len(report.Deleted) != 1 || report.Failed["failed"] == nil {
+		t.Fatalf("deleted %v failed %v err %v", report.Deleted, report.Failed, report.Err)
+	}
+	if fids, _ := store.Expired(time.Now(), defaultSweepBatch); len(fids) != 0 {
+		t.Fatalf("expired %v", fids)
+	}
+	if fids, _ := store.Expired(time.Now().Add(time.Hour), defaultSweepBatch); len(fids) != 1 || fids[0] != "failed" {
+		t.Fatalf("expired after interval %v", fids)
+	}
+}
+
+func TestSweepCloseDuringRetry(t *testing.T) {
+	store := newTestExpiryStore(t)
+	defer os.RemoveAll(filepath.Dir(store.path))
+
+	store.Add("failed", time.Now().Add(-time.Second))
+	var sw *sweeper
+	sw = newSweeper(ExpiryConfig{Store: store, Retries: 5, RetryDelay: time.Hour}, func(fid string) *Error {
+		close(sw.stop)
+		return NewError("Delete", errors.New("unavailable"))
+	})
+
+	done := make(chan *SweepReport)
+	go func() { done <- sw.sweep() }()
+	select {
+	case report := <-done:
+		if report.Failed["failed"] == nil {
+			t.Fatalf("failed %v", report.Failed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sweep waits for retry after close")
+	}
+}
@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// fileSizeAppenderFlag is set in file size encoded in the name of an appender file
+	fileSizeAppenderFlag uint64 = 1 << 58
+	// fileSizeTrunkFlag is set in file size encoded in the name of a file stored in trunk
+	fileSizeTrunkFlag uint64 = 1 << 59
+	// fileSizeRandomFlag is set if high 32 bits of encoded file size are random
+	fileSizeRandomFlag uint64 = 1 << 63
+)
+
+// FileID holds fields decoded from a file id without asking the server.
+type FileID struct {
+	// Group is the storage group name
+	Group string
+
+	// Filename is the file name in group, like M00/00/00/xxx.jpg
+	Filename string
+
+	// StorePathIndex is store path of the storage, decoded from M00
+	StorePathIndex int
+
+	// SourceIP is ip of the storage the file uploaded to
+	SourceIP string
+
+	// CreateTime is upload time in seconds
+	CreateTime time.Time
+
+	// Size is file size when uploaded. It is 0 for appender files, query file info instead.
+	Size int64
+
+	// Crc32 of file content. It is 0 for appender files.
+	Crc32 uint32
+
+	// Appender is true if the file can be appended, modified or truncated
+	Appender bool
+
+	// Trunk is true if the file is stored in a trunk file
+	Trunk bool
+
+	// Slave is true if the file is a slave file uploaded by UploadSlave
+	Slave bool
+}
+
+// DecodeFileID decode fields encoded in file id by storage server.
+func DecodeFileID(fid string) (*FileID, *Error) {
+	s := strings.SplitN(fid, "/", 2)
+	if len(s) < 2 {
+		return nil, wrongFidErr(fid)
+	}
+	id := &FileID{Group: s[0], Filename: s[1]}
+
+	//M00/00/00/ and base64 encoded fields follow it
+	name := id.Filename
+	if len(name) < FDFS_LOGIC_FILE_PATH_LEN+FDFS_FILENAME_BASE64_LENGTH || name[0] != 'M' {
+		return nil, decodeFidErr(fmt.Errorf("unexpected file name: %s", name))
+	}
+	index, err := strconv.ParseUint(name[1:3], 16, 8)
+	if err != nil {
+		return nil, decodeFidErr(err)
+	}
+	id.StorePathIndex = int(index)
+
+	encoded := name[FDFS_LOGIC_FILE_PATH_LEN : FDFS_LOGIC_FILE_PATH_LEN+FDFS_FILENAME_BASE64_LENGTH]
+	fields, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, decodeFidErr(err)
+	}
+	//4 bytes: source ip
+	id.SourceIP = net.IPv4(fields[0], fields[1], fields[2], fields[3]).String()
+	//4 bytes: create timestamp
+	id.CreateTime = time.Unix(int64(binary.BigEndian.Uint32(fields[4:8])), 0)
+	//8 bytes: file size and flags
+	size := binary.BigEndian.Uint64(fields[8:16])
+	//4 bytes: crc32
+	crc32 := binary.BigEndian.Uint32(fields[16:20])
+
+	id.Appender = size&fileSizeAppenderFlag != 0
+	id.Trunk = size&fileSizeTrunkFlag != 0
+	switch {
+	case id.Appender:
+	case id.Trunk || size&fileSizeRandomFlag != 0:
+		id.Size = int64(size & 0xFFFFFFFF)
+		id.Crc32 = crc32
+	default:
+		id.Size = int64(size)
+		id.Crc32 = crc32
+	}
+
+	//the rest is trunk info and extension name, a slave file has a suffix before extension name
+	rest := name[FDFS_LOGIC_FILE_PATH_LEN+FDFS_FILENAME_BASE64_LENGTH:]
+	if i := strings.IndexByte(rest, '.'); i >= 0 {
+		rest = rest[:i]
+	}
+	if id.Trunk {
+		id.Slave = len(rest) > FDFS_TRUNK_FILE_INFO_LEN
+	} else {
+		id.Slave = len(rest) > 0
+	}
+	return id, nil
+}
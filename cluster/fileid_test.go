@@ -0,0 +1,39 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeFileID(t *testing.T) {
+	fields := make([]byte, 20)
+	copy(fields, []byte{192, 168, 1, 10})
+	binary.BigEndian.PutUint32(fields[4:], 1536665400)
+	binary.BigEndian.PutUint64(fields[8:], 0x80123456<<32|1024)
+	binary.BigEndian.PutUint32(fields[16:], 0xdeadbeef)
+	fid := "group1/M01/00/2A/" + base64.RawURLEncoding.EncodeToString(fields) + ".jpg"
+
+	id, err := DecodeFileID(fid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Group != "group1" || id.StorePathIndex != 1 || id.SourceIP != "192.168.1.10" ||
+		id.CreateTime.Unix() != 1536665400 || id.Size != 1024 || id.Crc32 != 0xdeadbeef ||
+		id.Appender || id.Trunk || id.Slave {
+		t.Errorf("decode %s return %+v", fid, id)
+	}
+
+	binary.BigEndian.PutUint64(fields[8:], fileSizeAppenderFlag)
+	id, err = DecodeFileID("group1/M00/00/00/" + base64.RawURLEncoding.EncodeToString(fields) + "_150x150.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.Appender || !id.Slave || id.Size != 0 {
+		t.Errorf("decode appender slave return %+v", id)
+	}
+
+	if _, err := DecodeFileID("group1/short.jpg"); err == nil {
+		t.Error("decode a wrong file name should fail")
+	}
+}
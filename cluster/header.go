@@ -1,24 +1,49 @@
 package cluster
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 )
 
+// headerLen is pkg_len(8) cmd(1) status(1)
+const headerLen = FDFS_PROTO_PKG_LEN_SIZE + FDFS_PROTO_CMD_SIZE + FDFS_PROTO_STATUS_SIZE
+
+// errFileNotExist is response status code 2
+var errFileNotExist = errors.New("receive fileNotExist status code 2")
+
 type header struct {
 	pkgLen int64
 	cmd    byte
 	status byte
 }
 
-// Return header as a bytes buffer
-func (h *header) buffer() *bytes.Buffer {
-	buffer := new(bytes.Buffer)
-	binary.Write(buffer, binary.BigEndian, h.pkgLen)
+// reqBuffer holds an encoded request header with the fixed length fields follow it.
+// It is taken from a pool by header.buffer and returned by request after the response is read.
+type reqBuffer struct {
+	b []byte
+
+	// scratch receives the response header
+	scratch [headerLen]byte
+
+	// vec is backing array of bufs to send header and body in one writev
+	vec  [2][]byte
+	bufs net.Buffers
+}
+
+var reqBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &reqBuffer{b: make([]byte, 0, 256)}
+	},
+}
+
+// Return header encoded in a pooled buffer
+func (h *header) buffer() *reqBuffer {
+	buffer := reqBufferPool.Get().(*reqBuffer)
+	buffer.WriteInt64(h.pkgLen)
 	//cmd
 	buffer.WriteByte(h.cmd)
 	//status
@@ -26,19 +51,67 @@ func (h *header) buffer() *bytes.Buffer {
 	return buffer
 }
 
-// Read fdfs header from conn
-func (h *header) read(conn net.Conn) error {
-	data := make([]byte, 10)
+// WriteInt64 append v in big endian.
+func (rb *reqBuffer) WriteInt64(v int64) {
+	n := len(rb.b)
+	rb.b = append(rb.b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(rb.b[n:], uint64(v))
+}
+
+// WriteByte append c. It always return nil.
+func (rb *reqBuffer) WriteByte(c byte) error {
+	rb.b = append(rb.b, c)
+	return nil
+}
+
+// WriteString append s.
+func (rb *reqBuffer) WriteString(s string) {
+	rb.b = append(rb.b, s...)
+}
+
+// WriteFixString append s truncated or zero padded to fixLength bytes.
+func (rb *reqBuffer) WriteFixString(s string, fixLength int) {
+	if len(s) > fixLength {
+		s = s[:fixLength]
+	}
+	rb.b = append(rb.b, s...)
+	for i := len(s); i < fixLength; i++ {
+		rb.b = append(rb.b, 0)
+	}
+}
+
+// Bytes return encoded bytes. They are valid until the buffer is released.
+func (rb *reqBuffer) Bytes() []byte {
+	return rb.b
+}
+
+// release return the buffer to pool.
+func (rb *reqBuffer) release() {
+	rb.b = rb.b[:0]
+	rb.vec = [2][]byte{}
+	rb.bufs = nil
+	reqBufferPool.Put(rb)
+}
+
+// buffers return header and body as net.Buffers backed by the pooled buffer.
+func (rb *reqBuffer) buffers(body []byte) *net.Buffers {
+	rb.vec[0], rb.vec[1] = rb.b, body
+	rb.bufs = rb.vec[:]
+	return &rb.bufs
+}
+
+// Read fdfs header from conn. buf is used to receive the header and must be at least headerLen.
+func (h *header) read(conn io.Reader, buf []byte) error {
+	data := buf[:headerLen]
 	if _, err := io.ReadFull(conn, data); err != nil {
 		return err
 	}
-	buff := bytes.NewBuffer(data)
-	binary.Read(buff, binary.BigEndian, &h.pkgLen)
+	h.pkgLen = int64(binary.BigEndian.Uint64(data))
 	if h.pkgLen < 0 {
 		return fmt.Errorf("wrong pkg length: %d", h.pkgLen)
 	}
-	h.cmd, _ = buff.ReadByte()
-	h.status, _ = buff.ReadByte()
+	h.cmd = data[8]
+	h.status = data[9]
 	if h.status != 0 {
 		return h.statusCodeErr()
 	}
@@ -48,7 +121,7 @@ func (h *header) read(conn net.Conn) error {
 func (h *header) statusCodeErr() error {
 	switch h.status {
 	case 2:
-		return errors.New("receive fileNotExist status code 2")
+		return errFileNotExist
 	case 22:
 		return errors.New("receive invalidParameter status code 22")
 	default:
@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+const benchFilename = "M01/DE/79/CgIG6VuXIoeAbiwbAAAIIRe5FG4412.jpg"
+
+// encodeDownload encode a download request the way storage does.
+func encodeDownload(filename string) *reqBuffer {
+	h := &header{
+		pkgLen: int64(32 + len(filename)),
+		cmd:    STORAGE_PROTO_CMD_DOWNLOAD_FILE,
+	}
+	buffer := h.buffer()
+	buffer.WriteInt64(0)
+	buffer.WriteInt64(0)
+	buffer.WriteFixString("g1", FDFS_GROUP_NAME_MAX_LEN)
+	buffer.WriteString(filename)
+	return buffer
+}
+
+// encodeDownloadBytesBuffer encode a download request with bytes.Buffer and binary.Write,
+// as a baseline of the pooled encoder.
+func encodeDownloadBytesBuffer(filename string) []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, int64(32+len(filename)))
+	buffer.WriteByte(STORAGE_PROTO_CMD_DOWNLOAD_FILE)
+	buffer.WriteByte(0)
+	binary.Write(buffer, binary.BigEndian, int64(0))
+	binary.Write(buffer, binary.BigEndian, int64(0))
+	buffer.WriteString(fixString("g1", FDFS_GROUP_NAME_MAX_LEN))
+	buffer.WriteString(filename)
+	return buffer.Bytes()
+}
+
+func TestHeaderBuffer(t *testing.T) {
+	buffer := encodeDownload(benchFilename)
+	if !bytes.Equal(buffer.Bytes(), encodeDownloadBytesBuffer(benchFilename)) {
+		t.Errorf("encoded request %v != %v", buffer.Bytes(), encodeDownloadBytesBuffer(benchFilename))
+	}
+	buffer.release()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		encodeDownload(benchFilename).release()
+	})
+	if allocs != 0 {
+		t.Errorf("encode request allocs %v times, want 0", allocs)
+	}
+}
+
+func TestHeaderRead(t *testing.T) {
+	buffer := (&header{pkgLen: 40, cmd: STORAGE_PROTO_CMD_RESP}).buffer()
+	defer buffer.release()
+
+	var scratch [headerLen]byte
+	h := header{}
+	if err := h.read(bytes.NewReader(buffer.Bytes()), scratch[:]); err != nil {
+		t.Fatal(err)
+	}
+	if h.pkgLen != 40 || h.cmd != STORAGE_PROTO_CMD_RESP || h.status != 0 {
+		t.Errorf("read header %+v", h)
+	}
+
+	buffer = (&header{status: 2}).buffer()
+	defer buffer.release()
+	if err := h.read(bytes.NewReader(buffer.Bytes()), scratch[:]); err == nil {
+		t.Error("read header with status 2 should fail")
+	}
+}
+
+func BenchmarkEncodeRequest(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeDownload(benchFilename).release()
+	}
+}
+
+func BenchmarkEncodeRequestBytesBuffer(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeDownloadBytesBuffer(benchFilename)
+	}
+}
+
+func BenchmarkReadHeader(b *testing.B) {
+	buffer := (&header{pkgLen: 40, cmd: STORAGE_PROTO_CMD_RESP}).buffer()
+	defer buffer.release()
+	r := bytes.NewReader(buffer.Bytes())
+	var scratch [headerLen]byte
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(buffer.Bytes())
+		h := header{}
+		h.read(r, scratch[:])
+	}
+}
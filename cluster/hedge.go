@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// hedgeWindow is number of recent download latencies kept to compute the percentile
+	hedgeWindow = 256
+	// hedgeMinSamples is number of latencies needed before the percentile is used
+	hedgeMinSamples = 20
+	// defaultHedgeDelay is delay before enough latencies are observed if Delay is not set
+	defaultHedgeDelay = 100 * time.Millisecond
+)
+
+// HedgePolicy defines when a download is hedged: the same request is sent to another storage
+// holding the file if the first one has not responded in time, and the first success is used.
+type HedgePolicy struct {
+	// Delay is time to wait for the first storage before hedging.
+	Delay time.Duration
+
+	// Percentile of recent download latencies used as the delay instead of Delay, in (0, 100),
+	// e.g. 95 hedges downloads slower than 95% of recent ones. Delay is used until enough
+	// latencies are observed, 100ms if not set.
+	Percentile float64
+}
+
+// hedger decides hedge delay from policy and observed latencies.
+type hedger struct {
+	policy HedgePolicy
+
+	latencies []time.Duration
+	next      int
+
+	mtx sync.Mutex
+}
+
+func newHedger(policy HedgePolicy) *hedger {
+	if policy.Percentile > 0 && policy.Delay <= 0 {
+		//hedging every download at once before latencies are observed doubles the load
+		policy.Delay = defaultHedgeDelay
+	}
+	return &hedger{
+		policy:    policy,
+		latencies: make([]time.Duration, 0, hedgeWindow),
+	}
+}
+
+// observe record latency of a successful download
+func (h *hedger) observe(d time.Duration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if len(h.latencies) < hedgeWindow {
+		h.latencies = append(h.latencies, d)
+		return
+	}
+	h.latencies[h.next] = d
+	h.next = (h.next + 1) % hedgeWindow
+}
+
+// delay return time to wait before hedging
+func (h *hedger) delay() time.Duration {
+	if h.policy.Percentile <= 0 || h.policy.Percentile >= 100 {
+		return h.policy.Delay
+	}
+
+	h.mtx.Lock()
+	if len(h.latencies) < hedgeMinSamples {
+		h.mtx.Unlock()
+		return h.policy.Delay
+	}
+	sorted := append([]time.Duration(nil), h.latencies...)
+	h.mtx.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(float64(len(sorted)-1)*h.policy.Percentile/100)]
+}
+
+// hedgeResult is result of one of the hedged downloads
+type hedgeResult struct {
+	b   []byte
+	err *Error
+}
+
+// downloadHedged download the range from a storage holding the file. If it has not responded
+// after hedge delay or failed, the request is sent to another storage. The first success is
+// returned and the other download is aborted. Both are aborted when ctx is done.
+func (c *Cluster) downloadHedged(ctx context.Context, h *hedger, fid string, offset, length int64) ([]byte, *Error) {
+	if _, ok := c.recentUpload(fid); ok {
+		//only the source storage is sure to have the file
+		return c.download(ctx, fid, offset, length, nil)
+	}
+	group, filename, err := c.splitFid(fid)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkTrash(fid); err != nil {
+		return nil, err
+	}
+	//query all storage holding the file from tracker
+	infos, err := c.Tracker().QueryDownloadStorageAll(group, filename)
+	if err != nil {
+		return nil, c.wrapError(err)
+	}
+	if len(infos) < 2 {
+		//nothing to hedge with
+		return c.download(ctx, fid, offset, length, nil)
+	}
+	first := rand.Intn(len(infos))
+	second := (first + 1 + rand.Intn(len(infos)-1)) % len(infos)
+
+	//loser is aborted when the winner returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan hedgeResult, 2)
+	fetch := func(info *TrackerStoreInfo) {
+		s, err := c.Storage(info)
+		if err != nil {
+			results <- hedgeResult{err: err}
+			return
+		}
+		start := time.Now()
+		b, err := s.DownloadContext(ctx, filename, offset, length)
+		if err == nil {
+			h.observe(time.Since(start))
+		}
+		results <- hedgeResult{b: b, err: c.wrapError(err)}
+	}
+
+	go fetch(infos[first])
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+	hedged, pending := false, 1
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.b, nil
+			}
+			if !hedged {
+				//first storage failed, no need to wait for the delay
+				go fetch(infos[second])
+				hedged, pending = true, pending+1
+			} else if pending == 0 {
+				return nil, r.err
+			}
+		case <-timer.C:
+			if !hedged {
+				go fetch(infos[second])
+				hedged, pending = true, pending+1
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestHedgerDelay(t *testing.T) {
+	h := newHedger(HedgePolicy{Delay: time.Second, Percentile: 90})
+	for i := 1; i < hedgeMinSamples; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := h.delay(); d != time.Second {
+		t.Errorf("delay %v before enough samples, want %v", d, time.Second)
+	}
+	for i := 0; i < hedgeWindow; i++ {
+		h.observe(time.Duration(i%100+1) * time.Millisecond)
+	}
+	if d := h.delay(); d < 85*time.Millisecond || d > 95*time.Millisecond {
+		t.Errorf("p90 delay %v, want about 90ms", d)
+	}
+
+	if d := newHedger(HedgePolicy{Percentile: 90}).delay(); d != defaultHedgeDelay {
+		t.Errorf("delay %v without Delay, want default %v", d, defaultHedgeDelay)
+	}
+}
+
+func TestCastFetchInfos(t *testing.T) {
+	recv := []byte(fixString("g1", 16) + fixString("10.0.0.1", 15))
+	port := make([]byte, 8)
+	binary.BigEndian.PutUint64(port, 23000)
+	recv = append(recv, port...)
+	recv = append(recv, fixString("10.0.0.2", 15)...)
+
+	infos, err := castFetchInfos(recv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Address != "10.0.0.1:23000" || infos[1].Address != "10.0.0.2:23000" || infos[1].Group != "g1" {
+		t.Errorf("cast return %+v %+v", infos[0], infos[len(infos)-1])
+	}
+	if _, err := castFetchInfos(recv[:len(recv)-1]); err == nil {
+		t.Error("cast wrong length should fail")
+	}
+}
@@ -0,0 +1,238 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// IdempotencyRecord records the upload of an idempotency key.
+type IdempotencyRecord struct {
+	// Fid is the uploaded file
+	Fid string `json:"fid"`
+
+	// Hash is hex encoded SHA-256 of the content
+	Hash string `json:"sha256"`
+
+	// Done is true if the upload returned the fid to caller. An upload not done is half completed,
+	// the caller may not know the fid.
+	Done bool `json:"done"`
+}
+
+// IdempotencyStore persists idempotency records by key.
+type IdempotencyStore interface {
+	// Load return the record of key. It returns nil record and nil error if not exist.
+	Load(key string) (*IdempotencyRecord, error)
+
+	// Save record of key, overwrite the old one.
+	Save(key string, r *IdempotencyRecord) error
+
+	// Remove record of key. Remove a not exist key is not an error.
+	Remove(key string) error
+}
+
+// FileIdempotencyStore stores each record as a json file under a directory.
+type FileIdempotencyStore struct {
+	dir string
+
+	mtx sync.Mutex
+}
+
+// NewFileIdempotencyStore create an idempotency store saving files to dir.
+// The directory will be created if not exist.
+func NewFileIdempotencyStore(dir string) (*FileIdempotencyStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileIdempotencyStore{dir: dir}, nil
+}
+
+// Load record of key from its file.
+func (fs *FileIdempotencyStore) Load(key string) (*IdempotencyRecord, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	b, err := ioutil.ReadFile(fs.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &IdempotencyRecord{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Save record of key. The file is replaced atomically.
+func (fs *FileIdempotencyStore) Save(key string, r *IdempotencyRecord) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.path(key), b)
+}
+
+// Remove record file of key.
+func (fs *FileIdempotencyStore) Remove(key string) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if err := os.Remove(fs.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path return record file path. Key is hashed because it may contain path separators.
+func (fs *FileIdempotencyStore) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(fs.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// idempotencyLocks serialize uploads of the same key in the process, keys are hashed to a lock
+var idempotencyLocks [64]sync.Mutex
+
+func idempotencyLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &idempotencyLocks[h.Sum32()%uint32(len(idempotencyLocks))]
+}
+
+// SetIdempotencyStore set the store used by UploadIdempotent.
+//
+// SetIdempotencyStore is a wrapper of DefaultCluster.SetIdempotencyStore.
+func SetIdempotencyStore(store IdempotencyStore) {
+	DefaultCluster.SetIdempotencyStore(store)
+}
+
+// SetIdempotencyStore set the store used by UploadIdempotent.
+func (c *Cluster) SetIdempotencyStore(store IdempotencyStore) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.idempotency = store
+}
+
+func (c *Cluster) idempotencyStore() (IdempotencyStore, *Error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.idempotency == nil {
+		return nil, c.wrapError(idempotencyErr(errors.New("idempotency store is not set")))
+	}
+	return c.idempotency, nil
+}
+
+// UploadIdempotent upload a file once for the key.
+//
+// UploadIdempotent is a wrapper of DefaultCluster.UploadIdempotent.
+func UploadIdempotent(ctx context.Context, key string, b []byte, group, ext string) (string, error) {
+	return DefaultCluster.UploadIdempotent(ctx, key, b, group, ext)
+}
+
+// UploadIdempotent upload a file to the group with specified extension name once for the key,
+// such as a client request id. The fid is recorded in the idempotency store before it is returned,
+// and a repeat call with the key returns the recorded fid without uploading.
+//
+// Ctx is checked before uploading but does not abort the upload. If ctx is done by the time the
+// upload finishes, it is recorded as half completed and the ctx error is returned. A retry with the
+// same content reconciles it and returns its fid if the file still exists. A retry with different
+// content deletes it and uploads the new content. Reusing the key of a completed upload for different
+// content is an error.
+//
+// A crash between the upload and saving its record leaves the file orphan, it is never reconciled.
+// Calls with the same key are serialized in the process, but not across processes sharing a store.
+func (c *Cluster) UploadIdempotent(ctx context.Context, key string, b []byte, group, ext string) (string, error) {
+	fid, err := c.uploadIdempotent(ctx, key, b, group, ext)
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
+}
+
+func (c *Cluster) uploadIdempotent(ctx context.Context, key string, b []byte, group, ext string) (string, *Error) {
+	store, err := c.idempotencyStore()
+	if err != nil {
+		return "", err
+	}
+	if e := ctx.Err(); e != nil {
+		return "", c.wrapError(contextErr(e))
+	}
+	lock := idempotencyLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	r, e := store.Load(key)
+	if e != nil {
+		return "", c.wrapError(idempotencyErr(e))
+	}
+	if r != nil {
+		if fid, ok, err := c.reconcileIdempotent(store, key, r, hash, c.queryFileInfo, c.delete); err != nil || ok {
+			return fid, err
+		}
+	}
+
+	fid, err := c.upload(b, group, ext, false, nil)
+	if err != nil {
+		return "", err
+	}
+	r = &IdempotencyRecord{Fid: fid, Hash: hash, Done: ctx.Err() == nil}
+	if e := store.Save(key, r); e != nil {
+		//an unrecorded file would be uploaded again by a retry
+		c.delete(fid)
+		return "", c.wrapError(idempotencyErr(e))
+	}
+	if !r.Done {
+		return "", c.wrapError(contextErr(ctx.Err()))
+	}
+	return fid, nil
+}
+
+// reconcileIdempotent return the recorded fid and true if it can be returned for content hash.
+// A half completed upload of different content or gone is deleted and removed, then false is returned.
+// Files are queried by stat and deleted by remove.
+func (c *Cluster) reconcileIdempotent(store IdempotencyStore, key string, r *IdempotencyRecord, hash string,
+	stat func(fid string) (*FileInfo, *Error), remove func(fid string) *Error) (string, bool, *Error) {
+	if r.Hash != hash {
+		if r.Done {
+			return "", false, c.wrapError(idempotencyErr(errors.New("key is used by an upload of different content")))
+		}
+		if err := remove(r.Fid); err != nil && !isFileNotExist(err) {
+			return "", false, err
+		}
+	} else if r.Done {
+		return r.Fid, true, nil
+	} else {
+		_, err := stat(r.Fid)
+		if err == nil {
+			r.Done = true
+			if e := store.Save(key, r); e != nil {
+				return "", false, c.wrapError(idempotencyErr(e))
+			}
+			return r.Fid, true, nil
+		}
+		if !isFileNotExist(err) {
+			return "", false, err
+		}
+	}
+	if e := store.Remove(key); e != nil {
+		return "", false, c.wrapError(idempotencyErr(e))
+	}
+	return "", false, nil
+}
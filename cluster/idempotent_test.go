@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileIdempotencyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_idempotency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileIdempotencyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "POST /upload 8f14e45f-ceea-467a-9575-7a7c1a3e4f5d"
+	r, err := store.Load(key)
+	if err != nil || r != nil {
+		t.Fatalf("load not exist key, got %v, %v", r, err)
+	}
+
+	want := IdempotencyRecord{Fid: "g1/M00/00/00/CgIG6VuXIoeAbiwbAAAIIRe5FG4412.txt", Hash: "5891b5b522d5df08", Done: true}
+	if err := store.Save(key, &want); err != nil {
+		t.Fatal(err)
+	}
+	r, err = store.Load(key)
+	if err != nil || r == nil || *r != want {
+		t.Fatalf("load saved key, got %v, %v", r, err)
+	}
+
+	if err := store.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove(key); err != nil {
+		t.Fatalf("remove not exist key: %v", err)
+	}
+	r, err = store.Load(key)
+	if err != nil || r != nil {
+		t.Fatalf("load removed key, got %v, %v", r, err)
+	}
+}
+
+func TestReconcileIdempotent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_idempotency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileIdempotencyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New("test")
+	key := "POST /upload 8f14e45f-ceea-467a-9575-7a7c1a3e4f5d"
+	fid := "g1/M00/00/00/CgIG6VuXIoeAbiwbAAAIIRe5FG4412.txt"
+	files := map[string]bool{fid: true}
+	stat := func(fid string) (*FileInfo, *Error) {
+		if !files[fid] {
+			return nil, NewError("StatusErr", errFileNotExist)
+		}
+		return &FileInfo{}, nil
+	}
+	remove := func(fid string) *Error {
+		delete(files, fid)
+		return nil
+	}
+
+	//same content pending, the file exists
+	r := &IdempotencyRecord{Fid: fid, Hash: "aaaa"}
+	got, ok, e := c.reconcileIdempotent(store, key, r, "aaaa", stat, remove)
+	if e != nil || !ok || got != fid {
+		t.Fatalf("reconcile same content, got %q, %v, %v", got, ok, e)
+	}
+	if saved, _ := store.Load(key); saved == nil || !saved.Done {
+		t.Fatalf("reconciled record is not done, got %v", saved)
+	}
+
+	//different content pending, the file is deleted
+	r = &IdempotencyRecord{Fid: fid, Hash: "aaaa"}
+	got, ok, e = c.reconcileIdempotent(store, key, r, "bbbb", stat, remove)
+	if e != nil || ok {
+		t.Fatalf("reconcile different content, got %q, %v, %v", got, ok, e)
+	}
+	if files[fid] {
+		t.Error("half completed upload of different content is kept")
+	}
+	if saved, _ := store.Load(key); saved != nil {
+		t.Fatalf("record of different content is kept, got %v", saved)
+	}
+
+	//different content after done
+	files[fid] = true
+	r = &IdempotencyRecord{Fid: fid, Hash: "aaaa", Done: true}
+	if _, ok, e = c.reconcileIdempotent(store, key, r, "bbbb", stat, remove); e == nil || ok {
+		t.Fatalf("reuse key of done upload, got %v, %v", ok, e)
+	}
+	if !files[fid] {
+		t.Error("done upload is deleted")
+	}
+}
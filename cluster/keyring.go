@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// KeyProvider wraps data keys of encrypted files with master keys it holds, so data keys
+// can be stored beside the files. Implementations must be safe for concurrent use.
+type KeyProvider interface {
+	// WrapKey encrypt the data key with the current master key and return id of the master key.
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+
+	// UnwrapKey decrypt the data key wrapped by master key keyID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// wrapKey seal the data key by AES-GCM with kek, the key id is authenticated as well.
+// The result is nonce followed by sealed data key.
+func wrapKey(kek []byte, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// unwrapKey open the data key sealed by wrapKey
+func unwrapKey(kek []byte, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// StaticKeyProvider wraps data keys with a single master key.
+type StaticKeyProvider struct {
+	id  string
+	key []byte
+}
+
+// NewStaticKeyProvider create a key provider of master key with the id. Key is 16, 24 or 32 bytes
+// for AES-128, AES-192 or AES-256.
+func NewStaticKeyProvider(id string, key []byte) (*StaticKeyProvider, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{id: id, key: append([]byte(nil), key...)}, nil
+}
+
+// WrapKey encrypt the data key with the master key.
+func (sp *StaticKeyProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	wrapped, err := wrapKey(sp.key, sp.id, dataKey)
+	return sp.id, wrapped, err
+}
+
+// UnwrapKey decrypt the data key, keyID must be id of the master key.
+func (sp *StaticKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != sp.id {
+		return nil, fmt.Errorf("unknown key id %q", keyID)
+	}
+	return unwrapKey(sp.key, keyID, wrapped)
+}
+
+// keyringFile is the json format of keyring file
+type keyringFile struct {
+	// Current is id of the key wrapping new data keys
+	Current string `json:"current"`
+
+	// Keys are base64 encoded master keys by id
+	Keys map[string]string `json:"keys"`
+}
+
+// FileKeyring wraps data keys with master keys loaded from a json file like:
+//
+//	{"current": "2018-09", "keys": {"2018-03": "base64 key", "2018-09": "base64 key"}}
+//
+// To rotate, add a new key, make it current and call Reload. Old keys must be kept
+// until data keys wrapped by them are rewrapped.
+type FileKeyring struct {
+	path string
+
+	current string
+	keys    map[string][]byte
+
+	mtx sync.RWMutex
+}
+
+// NewFileKeyring load master keys from the file.
+func NewFileKeyring(path string) (*FileKeyring, error) {
+	kr := &FileKeyring{path: path}
+	if err := kr.Reload(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Reload master keys from the file. Keys in use are kept if the file is invalid.
+func (kr *FileKeyring) Reload() error {
+	b, err := ioutil.ReadFile(kr.path)
+	if err != nil {
+		return err
+	}
+	var f keyringFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, s := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("key %q: %s", id, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return fmt.Errorf("key %q: %s", id, err)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[f.Current]; !ok {
+		return fmt.Errorf("current key %q not in keyring", f.Current)
+	}
+
+	kr.mtx.Lock()
+	defer kr.mtx.Unlock()
+
+	kr.current, kr.keys = f.Current, keys
+	return nil
+}
+
+// WrapKey encrypt the data key with the current master key.
+func (kr *FileKeyring) WrapKey(dataKey []byte) (string, []byte, error) {
+	kr.mtx.RLock()
+	id, key := kr.current, kr.keys[kr.current]
+	kr.mtx.RUnlock()
+
+	wrapped, err := wrapKey(key, id, dataKey)
+	return id, wrapped, err
+}
+
+// UnwrapKey decrypt the data key with master key keyID.
+func (kr *FileKeyring) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	kr.mtx.RLock()
+	key, ok := kr.keys[keyID]
+	kr.mtx.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", keyID)
+	}
+	return unwrapKey(key, keyID, wrapped)
+}
@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	"encoding/json"
+)
+
+// manifestExt is extension name of manifest files
+const manifestExt = "json"
+
+// uploadManifest upload v encoded in json to the group and return its fid.
+func (c *Cluster) uploadManifest(v interface{}, group string) (string, *Error) {
+	b, e := json.Marshal(v)
+	if e != nil {
+		return "", c.wrapError(manifestErr(e))
+	}
+	return c.upload(b, group, manifestExt, false, nil)
+}
+
+// downloadManifest download the manifest file and decode it into v.
+func (c *Cluster) downloadManifest(fid string, v interface{}) *Error {
+	b, err := c.DownloadFromOffset(fid, 0, 0)
+	if err != nil {
+		return err
+	}
+	if e := json.Unmarshal(b, v); e != nil {
+		return c.wrapError(manifestErr(e))
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package cluster
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// defaultPlacementRefresh is interval to refresh group stats if it is not set
+const defaultPlacementRefresh = 30 * time.Second
+
+// PlacementPolicy chooses a group to upload to.
+type PlacementPolicy interface {
+	// Choose return name of one of groups. Groups is never empty. Key is given by caller
+	// of Placement.Group or Placement.Upload, it may be empty.
+	Choose(key string, groups []*GroupStat) string
+}
+
+// PlacementConfig defines candidate groups and how to choose one of them.
+type PlacementConfig struct {
+	// Groups are candidate groups. Empty means all groups of the cluster.
+	Groups []string
+
+	// Policy chooses a group from candidates. Default MostFreeSpace.
+	Policy PlacementPolicy
+
+	// RefreshInterval is interval to refresh group stats from tracker. Default 30s.
+	RefreshInterval time.Duration
+
+	// MinFreeMB skips groups with less free space, e.g. reserved storage space of the cluster.
+	// Groups without active storage are always skipped.
+	MinFreeMB int64
+}
+
+// Placement chooses a group for each upload by a policy, using group stats refreshed from tracker.
+type Placement struct {
+	cluster *Cluster
+	config  PlacementConfig
+
+	// stats of candidate groups able to accept uploads
+	stats  []*GroupStat
+	expire time.Time
+
+	mtx sync.Mutex
+}
+
+// NewPlacement create a placement of DefaultCluster.
+//
+// NewPlacement is a wrapper of DefaultCluster.NewPlacement.
+func NewPlacement(config PlacementConfig) *Placement {
+	return DefaultCluster.NewPlacement(config)
+}
+
+// NewPlacement create a placement choosing groups of the cluster by config.
+func (c *Cluster) NewPlacement(config PlacementConfig) *Placement {
+	if config.Policy == nil {
+		config.Policy = MostFreeSpace()
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultPlacementRefresh
+	}
+	return &Placement{cluster: c, config: config}
+}
+
+// Group return the group chosen for key.
+func (p *Placement) Group(key string) (string, error) {
+	group, err := p.group(key)
+	if err != nil {
+		return "", err
+	}
+	return group, nil
+}
+
+// Upload a file to the group chosen for key with specified extension name.
+// The upload cannot be appended bytes to. If it fails, group stats are refreshed before next choice.
+func (p *Placement) Upload(b []byte, key, ext string) (string, error) {
+	group, err := p.group(key)
+	if err != nil {
+		return "", err
+	}
+	fid, err := p.cluster.upload(b, group, ext, false, nil)
+	if err != nil {
+		p.invalidate()
+		return "", err
+	}
+	return fid, nil
+}
+
+func (p *Placement) group(key string) (string, *Error) {
+	stats, err := p.groupStats()
+	if err != nil {
+		return "", err
+	}
+	if len(stats) == 0 {
+		return "", p.cluster.wrapError(placementErr(errors.New("no group available for upload")))
+	}
+	return p.config.Policy.Choose(key, stats), nil
+}
+
+// groupStats return stats of available candidate groups, refreshed from tracker if expired.
+func (p *Placement) groupStats() ([]*GroupStat, *Error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	now := time.Now()
+	if now.Before(p.expire) {
+		return p.stats, nil
+	}
+	all, err := p.cluster.Tracker().ListGroups()
+	if err != nil {
+		return nil, p.cluster.wrapError(err)
+	}
+	candidates := make(map[string]bool, len(p.config.Groups))
+	for _, g := range p.config.Groups {
+		candidates[g] = true
+	}
+	stats := make([]*GroupStat, 0, len(all))
+	for _, gs := range all {
+		if len(candidates) > 0 && !candidates[gs.Name] {
+			continue
+		}
+		if gs.ActiveCount == 0 || gs.FreeMB < p.config.MinFreeMB {
+			continue
+		}
+		stats = append(stats, gs)
+	}
+	p.stats = stats
+	p.expire = now.Add(p.config.RefreshInterval)
+	return stats, nil
+}
+
+// invalidate make group stats refreshed on next choice
+func (p *Placement) invalidate() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.expire = time.Time{}
+}
+
+type mostFreeSpace struct{}
+
+// MostFreeSpace return a policy choosing the group with most free space.
+func MostFreeSpace() PlacementPolicy {
+	return mostFreeSpace{}
+}
+
+func (mostFreeSpace) Choose(key string, groups []*GroupStat) string {
+	best := groups[0]
+	for _, gs := range groups[1:] {
+		if gs.FreeMB > best.FreeMB {
+			best = gs
+		}
+	}
+	return best.Name
+}
+
+// weightedRoundRobin is smooth weighted round robin, groups are chosen in proportion to weights
+// and interleaved evenly.
+type weightedRoundRobin struct {
+	weights map[string]int
+	current map[string]int
+
+	mtx sync.Mutex
+}
+
+// WeightedRoundRobin return a policy choosing groups in turn in proportion to weights.
+// Groups not in weights have weight 1. Groups with weight 0 or less are chosen only if no other
+// group is available.
+func WeightedRoundRobin(weights map[string]int) PlacementPolicy {
+	return &weightedRoundRobin{weights: weights, current: make(map[string]int)}
+}
+
+func (w *weightedRoundRobin) Choose(key string, groups []*GroupStat) string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	total := 0
+	var best string
+	for _, gs := range groups {
+		weight, ok := w.weights[gs.Name]
+		if !ok {
+			weight = 1
+		}
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		w.current[gs.Name] += weight
+		if best == "" || w.current[gs.Name] > w.current[best] {
+			best = gs.Name
+		}
+	}
+	if best == "" {
+		return groups[0].Name
+	}
+	w.current[best] -= total
+	return best
+}
+
+type hashKey struct{}
+
+// HashKey return a policy choosing group by hash of key, so the same key always goes to the same
+// group while it is available. Rendezvous hashing is used, only keys of a group gone are moved.
+func HashKey() PlacementPolicy {
+	return hashKey{}
+}
+
+func (hashKey) Choose(key string, groups []*GroupStat) string {
+	var best string
+	var bestScore uint64
+	for _, gs := range groups {
+		h := fnv.New64a()
+		h.Write([]byte(gs.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best, bestScore = gs.Name, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPlacementPolicy(t *testing.T) {
+	groups := []*GroupStat{
+		{Name: "g1", FreeMB: 100},
+		{Name: "g2", FreeMB: 300},
+		{Name: "g3", FreeMB: 200},
+	}
+	if g := MostFreeSpace().Choose("", groups); g != "g2" {
+		t.Errorf("most free space choose %s, want g2", g)
+	}
+
+	wrr := WeightedRoundRobin(map[string]int{"g1": 3, "g2": 1, "g3": 0})
+	count := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		count[wrr.Choose("", groups)]++
+	}
+	if count["g1"] != 6 || count["g2"] != 2 || count["g3"] != 0 {
+		t.Errorf("weighted round robin choose %v, want g1:6 g2:2", count)
+	}
+
+	hk := HashKey()
+	moved := 0
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		g := hk.Choose(key, groups)
+		if g != hk.Choose(key, groups) {
+			t.Fatalf("hash key choose different groups for %s", key)
+		}
+		if g != "g3" && g != hk.Choose(key, groups[:2]) {
+			moved++
+		}
+	}
+	if moved != 0 {
+		t.Errorf("%d keys moved when another group is gone", moved)
+	}
+}
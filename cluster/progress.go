@@ -0,0 +1,34 @@
+package cluster
+
+// defaultProgressStep is bytes between two progress callbacks if step is not set.
+const defaultProgressStep = 64 * 1024
+
+// ProgressFunc is called with bytes transferred and total bytes of the file body.
+type ProgressFunc func(done, total int64)
+
+// Progress reports transfer progress of an upload or download.
+//
+// The body is written or read through the connection Step bytes at a time and Func is called
+// after every step. The last call of a non-empty body has done == total.
+type Progress struct {
+	// Func receives progress
+	Func ProgressFunc
+
+	// Step is bytes between two calls of Func. Default 64K if not set.
+	Step int64
+}
+
+// step return bytes per step
+func (p *Progress) step() int64 {
+	if p.Step <= 0 {
+		return defaultProgressStep
+	}
+	return p.Step
+}
+
+// report call Func if set
+func (p *Progress) report(done, total int64) {
+	if p != nil && p.Func != nil {
+		p.Func(done, total)
+	}
+}
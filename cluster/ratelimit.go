@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+)
+
+// throttleStep is max bytes transferred between two token waits when a rate limit is active.
+const throttleStep = 32 * 1024
+
+// rateLimiter is a token bucket limiting bytes per second. Bucket size is one second of rate.
+// A rate not greater than 0 means no limit.
+type rateLimiter struct {
+	rate   int64
+	tokens float64
+	last   time.Time
+
+	// now is time source, replaced in tests
+	now func() time.Time
+
+	mtx sync.Mutex
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	l := &rateLimiter{now: time.Now}
+	l.setRate(rate)
+	return l
+}
+
+// setRate change the rate. Tokens are refilled to a full bucket.
+func (l *rateLimiter) setRate(rate int64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.rate = rate
+	l.tokens = float64(rate)
+	l.last = l.now()
+}
+
+// active reports whether the limiter has a rate
+func (l *rateLimiter) active() bool {
+	if l == nil {
+		return false
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.rate > 0
+}
+
+// reserve take n tokens and return how long the caller must wait before using them.
+// Tokens may go negative, so n can be larger than the bucket.
+func (l *rateLimiter) reserve(n int64) time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// wait block until n bytes are allowed
+func (l *rateLimiter) wait(n int64) {
+	if l == nil {
+		return
+	}
+	if d := l.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// limiters are applied together, such as a storage limiter and its cluster limiter
+type limiters []*rateLimiter
+
+func (ls limiters) active() bool {
+	for _, l := range ls {
+		if l.active() {
+			return true
+		}
+	}
+	return false
+}
+
+func (ls limiters) wait(n int64) {
+	for _, l := range ls {
+		l.wait(n)
+	}
+}
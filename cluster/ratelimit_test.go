@@ -0,0 +1,36 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1536665400, 0)
+	l := &rateLimiter{now: func() time.Time { return now }}
+	l.setRate(1000)
+
+	if d := l.reserve(1000); d != 0 {
+		t.Errorf("full bucket should not wait, got %v", d)
+	}
+	if d := l.reserve(500); d != 500*time.Millisecond {
+		t.Errorf("empty bucket wait %v, want 500ms", d)
+	}
+	now = now.Add(time.Second)
+	if d := l.reserve(500); d != 0 {
+		t.Errorf("refilled bucket should not wait, got %v", d)
+	}
+	now = now.Add(time.Hour)
+	if d := l.reserve(3000); d != 2*time.Second {
+		t.Errorf("bucket is capped to one second of rate, wait %v, want 2s", d)
+	}
+
+	l.setRate(0)
+	if l.active() || l.reserve(1<<30) != 0 {
+		t.Error("zero rate should not limit")
+	}
+	var nilLimiter *rateLimiter
+	if nilLimiter.active() {
+		t.Error("nil limiter should not be active")
+	}
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+)
+
+// gfPoly is the primitive polynomial x^8+x^4+x^3+x^2+1 of GF(2^8)
+const gfPoly = 0x11d
+
+var (
+	gfExp [510]byte
+	gfLog [256]int
+
+	// gfMul is multiplication table, gfMul[a][b] = a*b in GF(2^8)
+	gfMul [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPoly
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMul[a][b] = gfExp[gfLog[a]+gfLog[b]]
+		}
+	}
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]*n%255]
+}
+
+// gfMatrix is a matrix over GF(2^8)
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m gfMatrix) mul(o gfMatrix) gfMatrix {
+	r := newGFMatrix(len(m), len(o[0]))
+	for i := range m {
+		for j := range o[0] {
+			var v byte
+			for k := range o {
+				v ^= gfMul[m[i][k]][o[k][j]]
+			}
+			r[i][j] = v
+		}
+	}
+	return r
+}
+
+// invert return inverse of the square matrix by Gauss-Jordan elimination
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	//augment with identity
+	a := newGFMatrix(n, 2*n)
+	for i := range m {
+		copy(a[i], m[i])
+		a[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("matrix is singular")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		scale := gfInv(a[col][col])
+		for j := range a[col] {
+			a[col][j] = gfMul[scale][a[col][j]]
+		}
+		for i := 0; i < n; i++ {
+			if i == col || a[i][col] == 0 {
+				continue
+			}
+			f := a[i][col]
+			for j := range a[i] {
+				a[i][j] ^= gfMul[f][a[col][j]]
+			}
+		}
+	}
+	inv := newGFMatrix(n, n)
+	for i := range inv {
+		copy(inv[i], a[i][n:])
+	}
+	return inv, nil
+}
+
+// reedSolomon is a systematic Reed-Solomon code of dataShards data and parityShards parity shards.
+// Any dataShards of all shards can reconstruct the data.
+type reedSolomon struct {
+	dataShards   int
+	parityShards int
+
+	// matrix is (data+parity)*data encoding matrix, top rows are identity
+	matrix gfMatrix
+}
+
+func newReedSolomon(dataShards, parityShards int) (*reedSolomon, error) {
+	if dataShards <= 0 || parityShards < 0 || dataShards+parityShards > 256 {
+		return nil, fmt.Errorf("invalid shards: %d data, %d parity", dataShards, parityShards)
+	}
+	total := dataShards + parityShards
+	//vandermonde matrix, any data rows of it are independent
+	vm := newGFMatrix(total, dataShards)
+	for r := range vm {
+		for c := range vm[r] {
+			vm[r][c] = gfPow(byte(r), c)
+		}
+	}
+	//multiply by inverse of the top square to make data shards unchanged by encoding
+	top, err := vm[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+	return &reedSolomon{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       vm.mul(top),
+	}, nil
+}
+
+// encode return parity shards of data shards, all shards are the same size.
+func (rs *reedSolomon) encode(data [][]byte) [][]byte {
+	size := len(data[0])
+	parity := make([][]byte, rs.parityShards)
+	for i := range parity {
+		parity[i] = make([]byte, size)
+		rs.combine(parity[i], rs.matrix[rs.dataShards+i], data)
+	}
+	return parity
+}
+
+// reconstructData fill missing data shards from any dataShards present shards.
+// shards is all shards in order, missing ones are nil.
+func (rs *reedSolomon) reconstructData(shards [][]byte) error {
+	rows := make([]int, 0, rs.dataShards)
+	present := make([][]byte, 0, rs.dataShards)
+	for i, s := range shards {
+		if s != nil && len(rows) < rs.dataShards {
+			rows = append(rows, i)
+			present = append(present, s)
+		}
+	}
+	if len(rows) < rs.dataShards {
+		return fmt.Errorf("%d shards present, need %d", len(rows), rs.dataShards)
+	}
+	for i, s := range present {
+		if len(s) != len(present[0]) {
+			return fmt.Errorf("shard %d has %d bytes, want %d", rows[i], len(s), len(present[0]))
+		}
+	}
+
+	sub := make(gfMatrix, rs.dataShards)
+	for i, r := range rows {
+		sub[i] = rs.matrix[r]
+	}
+	inv, err := sub.invert()
+	if err != nil {
+		return err
+	}
+	size := len(present[0])
+	for i := 0; i < rs.dataShards; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shards[i] = make([]byte, size)
+		rs.combine(shards[i], inv[i], present)
+	}
+	return nil
+}
+
+// combine set dst to linear combination of shards by coefficients
+func (rs *reedSolomon) combine(dst []byte, coefficients []byte, shards [][]byte) {
+	for c, s := range shards {
+		table := &gfMul[coefficients[c]]
+		for j, v := range s {
+			dst[j] ^= table[v]
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	rs, err := newReedSolomon(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([][]byte, 4)
+	for i := range data {
+		data[i] = make([]byte, 1000)
+		rand.Read(data[i])
+	}
+	shards := append(append([][]byte(nil), data...), rs.encode(data)...)
+
+	//lose any two shards
+	for a := 0; a < 6; a++ {
+		for b := a + 1; b < 6; b++ {
+			lost := append([][]byte(nil), shards...)
+			lost[a], lost[b] = nil, nil
+			if err := rs.reconstructData(lost); err != nil {
+				t.Fatalf("lose %d and %d: %s", a, b, err)
+			}
+			for i := range data {
+				if !bytes.Equal(lost[i], data[i]) {
+					t.Fatalf("lose %d and %d: data shard %d not reconstructed", a, b, i)
+				}
+			}
+		}
+	}
+
+	lost := append([][]byte(nil), shards...)
+	lost[0], lost[1], lost[5] = nil, nil, nil
+	if err := rs.reconstructData(lost); err == nil {
+		t.Error("reconstruct from 3 of 4 data shards should fail")
+	}
+}
+
+func TestReedSolomonShardSize(t *testing.T) {
+	rs, err := newReedSolomon(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := [][]byte{make([]byte, 100), make([]byte, 100)}
+	shards := append(append([][]byte(nil), data...), rs.encode(data)...)
+	shards[0], shards[2] = nil, shards[2][:50]
+	if err := rs.reconstructData(shards); err == nil {
+		t.Error("reconstruct from shards of different sizes should fail")
+	}
+}
+
+func TestUploadErasureDuplicateGroups(t *testing.T) {
+	c := New("test")
+	if _, err := c.UploadErasure([]byte("hello"), []string{"g1", "g2", "g1"}, 2, 1, "txt"); err == nil {
+		t.Error("upload shards to duplicate groups should fail")
+	}
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Replica is a copy of a file in one group.
+type Replica struct {
+	// Group the copy is uploaded to
+	Group string `json:"group"`
+
+	// Fid of the copy. It is empty if upload to the group failed.
+	Fid string `json:"fid"`
+}
+
+// ReplicaSet is copies of the same file in different groups of a cluster, for redundancy
+// beyond replication inside a group. Save it to find the copies later.
+type ReplicaSet struct {
+	Replicas []Replica `json:"replicas"`
+}
+
+// UploadReplicated upload a copy of b to each group with specified extension name.
+//
+// UploadReplicated is a wrapper of DefaultCluster.UploadReplicated.
+func UploadReplicated(b []byte, groups []string, ext string) (*ReplicaSet, error) {
+	return DefaultCluster.UploadReplicated(b, groups, ext)
+}
+
+// UploadReplicated upload a copy of b to each group concurrently with specified extension name.
+// The copies cannot be appended bytes to.
+//
+// The replica set is returned even if some uploads fail, with their fids empty. Call
+// RepairReplicaSet to upload them again. Groups must be different.
+func (c *Cluster) UploadReplicated(b []byte, groups []string, ext string) (*ReplicaSet, error) {
+	if group := duplicateGroup(groups); group != "" {
+		//copies in the same group would be lost together
+		return nil, c.wrapError(replicateErr(fmt.Errorf("group %s is given more than once", group)))
+	}
+	rs := &ReplicaSet{Replicas: make([]Replica, len(groups))}
+	errs := make([]*Error, len(groups))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		rs.Replicas[i].Group = group
+		wg.Add(1)
+		go func(i int, group string) {
+			defer wg.Done()
+			rs.Replicas[i].Fid, errs[i] = c.upload(b, group, ext, false, nil)
+		}(i, group)
+	}
+	wg.Wait()
+
+	if err := c.replicaSetErr(rs, errs); err != nil {
+		return rs, err
+	}
+	return rs, nil
+}
+
+// DownloadReplicaSet download the file from the first healthy copy.
+//
+// DownloadReplicaSet is a wrapper of DefaultCluster.DownloadReplicaSet.
+func DownloadReplicaSet(rs *ReplicaSet) ([]byte, error) {
+	return DefaultCluster.DownloadReplicaSet(rs)
+}
+
+// DownloadReplicaSet download the file from the first healthy copy. Copies are tried in order,
+// a copy failed is skipped and the next one is tried.
+func (c *Cluster) DownloadReplicaSet(rs *ReplicaSet) ([]byte, error) {
+	b, err := c.downloadReplicaSet(rs)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (c *Cluster) downloadReplicaSet(rs *ReplicaSet) ([]byte, *Error) {
+	var lastErr *Error
+	for _, r := range rs.Replicas {
+		if r.Fid == "" {
+			continue
+		}
+		b, err := c.DownloadFromOffset(r.Fid, 0, 0)
+		if err == nil {
+			return b, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, c.wrapError(replicateErr(errors.New("no copy uploaded")))
+	}
+	return nil, lastErr
+}
+
+// DeleteReplicaSet delete all copies of the file.
+//
+// DeleteReplicaSet is a wrapper of DefaultCluster.DeleteReplicaSet.
+func DeleteReplicaSet(rs *ReplicaSet) error {
+	return DefaultCluster.DeleteReplicaSet(rs)
+}
+
+// DeleteReplicaSet delete all copies of the file. A copy already gone is not an error.
+// Deleted copies have their fids cleared, so it can be called again to delete the rest.
+func (c *Cluster) DeleteReplicaSet(rs *ReplicaSet) error {
+	errs := make([]*Error, len(rs.Replicas))
+	for i, r := range rs.Replicas {
+		if r.Fid == "" {
+			continue
+		}
+		if err := c.Delete(r.Fid); err != nil && !isFileNotExist(err) {
+			errs[i] = err
+			continue
+		}
+		rs.Replicas[i].Fid = ""
+	}
+	if err := c.replicaSetErr(rs, errs); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RepairReplicaSet upload the file again to groups whose copy is missing.
+//
+// RepairReplicaSet is a wrapper of DefaultCluster.RepairReplicaSet.
+func RepairReplicaSet(rs *ReplicaSet) error {
+	return DefaultCluster.RepairReplicaSet(rs)
+}
+
+// RepairReplicaSet upload the file again to groups whose copy is missing: failed to upload or
+// not exist any more. Content is downloaded from a healthy copy. Fids of repaired copies are
+// updated in rs, save it again after repair. A copy whose storage is unreachable is not repaired.
+func (c *Cluster) RepairReplicaSet(rs *ReplicaSet) error {
+	missing := make([]int, 0, len(rs.Replicas))
+	for i, r := range rs.Replicas {
+		if r.Fid == "" {
+			missing = append(missing, i)
+			continue
+		}
+		if _, err := c.queryFileInfo(r.Fid); isFileNotExist(err) {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	b, err := c.downloadReplicaSet(rs)
+	if err != nil {
+		return err
+	}
+	ext := rs.ext()
+	errs := make([]*Error, len(rs.Replicas))
+	for _, i := range missing {
+		fid, err := c.upload(b, rs.Replicas[i].Group, ext, false, nil)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		rs.Replicas[i].Fid = fid
+	}
+	if err := c.replicaSetErr(rs, errs); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ext return extension name of the copies
+func (rs *ReplicaSet) ext() string {
+	for _, r := range rs.Replicas {
+		if r.Fid != "" {
+			return fileExt(r.Fid)
+		}
+	}
+	return ""
+}
+
+// replicaSetErr join errors of replicas into one, nil if there is no error
+func (c *Cluster) replicaSetErr(rs *ReplicaSet, errs []*Error) *Error {
+	var msgs []string
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", rs.Replicas[i].Group, err))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return c.wrapError(replicateErr(errors.New(strings.Join(msgs, "; "))))
+}
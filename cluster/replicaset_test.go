@@ -0,0 +1,24 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUploadReplicatedDuplicateGroups(t *testing.T) {
+	c := New("test")
+	if _, err := c.UploadReplicated([]byte("hello"), []string{"g1", "g2", "g1"}, "txt"); err == nil {
+		t.Error("upload copies to duplicate groups should fail")
+	}
+}
+
+func TestReplicaSetErr(t *testing.T) {
+	c := New("test")
+	rs := &ReplicaSet{Replicas: []Replica{{Group: "g1", Fid: "g1/M00/00/00/a.txt"}, {Group: "g2"}}}
+	if err := c.replicaSetErr(rs, make([]*Error, 2)); err != nil {
+		t.Errorf("no failed copy, got %v", err)
+	}
+	if err := c.replicaSetErr(rs, []*Error{nil, NewError("GetConnFromPoolErr", errors.New("refused"))}); err == nil {
+		t.Error("a failed copy should be reported")
+	}
+}
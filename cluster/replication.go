@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"context"
+	"time"
+)
+
+// replicationPollInterval is time between two polls of WaitForReplication
+const replicationPollInterval = 500 * time.Millisecond
+
+// ReplicaStatus is state of a file on one storage of its group.
+type ReplicaStatus struct {
+	// Address of the storage
+	Address string
+
+	// Synced is true if size and crc32 reported by the storage match the file
+	Synced bool
+
+	// Info is file info reported by the storage, nil if the query failed
+	Info *FileInfo
+
+	// Err is error of the file info query, e.g. the file is not synced to the storage yet
+	Err error
+}
+
+// WaitForReplication wait until the file is synced to at least minReplicas storage.
+//
+// WaitForReplication is a wrapper of DefaultCluster.WaitForReplication.
+func WaitForReplication(ctx context.Context, fid string, minReplicas int) ([]ReplicaStatus, error) {
+	return DefaultCluster.WaitForReplication(ctx, fid, minReplicas)
+}
+
+// WaitForReplication wait until the file is synced to at least minReplicas storage, or ctx is done.
+//
+// It polls tracker for all storage holding the file and queries file info on each of them.
+// A storage counts if it reports the size and crc32 encoded in fid, or reported by the source
+// storage for appender files. Status of each storage of the last poll is returned for diagnostics,
+// also when ctx is done first.
+func (c *Cluster) WaitForReplication(ctx context.Context, fid string, minReplicas int) ([]ReplicaStatus, error) {
+	group, filename, err := c.splitFid(fid)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []ReplicaStatus
+	e := pollUntil(ctx, replicationPollInterval, func() bool {
+		var synced int
+		statuses, synced, err = c.replicaStatus(fid, group, filename)
+		return err == nil && synced >= minReplicas
+	})
+	if e != nil {
+		return statuses, c.wrapError(contextErr(e))
+	}
+	return statuses, nil
+}
+
+// pollUntil call done every interval until it returns true, or return the error of ctx when ctx is done
+func pollUntil(ctx context.Context, interval time.Duration, done func() bool) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// replicaStatus query the file on all storage holding it and return number of synced ones
+func (c *Cluster) replicaStatus(fid, group, filename string) ([]ReplicaStatus, int, *Error) {
+	size, crc32, err := c.expectedFileInfo(fid)
+	if err != nil {
+		return nil, 0, err
+	}
+	//query all storage holding the file from tracker
+	infos, err := c.Tracker().QueryDownloadStorageAll(group, filename)
+	if err != nil {
+		return nil, 0, c.wrapError(err)
+	}
+
+	statuses := make([]ReplicaStatus, len(infos))
+	synced := 0
+	for i, info := range infos {
+		statuses[i].Address = info.Address
+		s, err := c.Storage(info)
+		if err != nil {
+			statuses[i].Err = err
+			continue
+		}
+		fi, err := s.QueryFileInfo(filename)
+		if err != nil {
+			statuses[i].Err = c.wrapError(err)
+			continue
+		}
+		statuses[i].Info = fi
+		if fi.Size == size && fi.Crc32 == crc32 {
+			statuses[i].Synced = true
+			synced++
+		}
+	}
+	return statuses, synced, nil
+}
+
+// expectedFileInfo return size and crc32 of the file. They are decoded from fid if possible,
+// otherwise queried from the source storage.
+func (c *Cluster) expectedFileInfo(fid string) (int64, uint32, *Error) {
+	if size, crc32, ok := fidFileInfo(fid); ok {
+		return size, crc32, nil
+	}
+	s, filename, err := c.updateStorage(fid)
+	if err != nil {
+		return 0, 0, err
+	}
+	fi, err := s.QueryFileInfo(filename)
+	if err != nil {
+		return 0, 0, c.wrapError(err)
+	}
+	return fi.Size, fi.Crc32, nil
+}
+
+// fidFileInfo return size and crc32 decoded from fid and true if they are of the file. An appender
+// file may be changed after upload, and fid of a slave file encodes its master.
+func fidFileInfo(fid string) (int64, uint32, bool) {
+	id, err := DecodeFileID(fid)
+	if err != nil || id.Appender || id.Slave {
+		return 0, 0, false
+	}
+	return id.Size, id.Crc32, true
+}
@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestPollUntil(t *testing.T) {
+	calls := 0
+	err := pollUntil(context.Background(), time.Millisecond, func() bool {
+		calls++
+		return calls == 3
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("poll until the third call, got %d calls, %v", calls, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	calls = 0
+	err = pollUntil(ctx, 5*time.Millisecond, func() bool {
+		calls++
+		return false
+	})
+	if err != context.DeadlineExceeded || calls < 2 {
+		t.Fatalf("poll until timeout, got %d calls, %v", calls, err)
+	}
+}
+
+func TestFidFileInfo(t *testing.T) {
+	fields := make([]byte, 20)
+	copy(fields, []byte{192, 168, 1, 10})
+	binary.BigEndian.PutUint32(fields[4:], 1536665400)
+	binary.BigEndian.PutUint64(fields[8:], 0x80123456<<32|1024)
+	binary.BigEndian.PutUint32(fields[16:], 0xdeadbeef)
+	fid := "group1/M01/00/2A/" + base64.RawURLEncoding.EncodeToString(fields)
+
+	//a normal file is not queried
+	size, crc32, err := New("test").expectedFileInfo(fid + ".jpg")
+	if err != nil || size != 1024 || crc32 != 0xdeadbeef {
+		t.Errorf("expected file info, got %d, %x, %v", size, crc32, err)
+	}
+
+	//fid of a slave file encodes its master
+	if _, _, ok := fidFileInfo(fid + "_150x150.jpg"); ok {
+		t.Error("slave file info should not be decoded from fid")
+	}
+
+	binary.BigEndian.PutUint64(fields[8:], fileSizeAppenderFlag|1024)
+	if _, _, ok := fidFileInfo("group1/M01/00/2A/" + base64.RawURLEncoding.EncodeToString(fields) + ".jpg"); ok {
+		t.Error("appender file info should not be decoded from fid")
+	}
+}
@@ -1,26 +1,87 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"github.com/giantpoplar/pool"
 	"io"
+	"net"
+	"os"
+	"time"
 )
 
+// sendfileSegment is max bytes sent by one sendfile call. Write deadline is renewed
+// before every segment, so a large body is bounded by IOTimeout per segment instead
+// of as a whole.
+const sendfileSegment = 4 * 1024 * 1024
+
 type request struct {
-	c         *pool.WrappedConn
-	header    []byte
+	c *pool.WrappedConn
+
+	// header is encoded request header and fixed fields. It is released after do return.
+	header    *reqBuffer
 	body      []byte
 	respLimit int64
+
+	// respBuf receives the response body if not nil. respLimit must not exceed its length.
+	respBuf []byte
+
+	// bodyReader is used as request body if body is nil. bodyLen bytes will be read from it.
+	bodyReader io.Reader
+	bodyLen    int64
+
+	// ioTimeout is write deadline when the body bypasses the wrapped conn.
+	// If not set, header and body are written through the wrapped conn separately.
+	ioTimeout time.Duration
+
+	// bodyProgress and respProgress report progress of request body and response body.
+	// If set, the body is transferred step by step.
+	bodyProgress *Progress
+	respProgress *Progress
+
+	// bodyLimiters and respLimiters throttle request body and response body bandwidth.
+	bodyLimiters limiters
+	respLimiters limiters
+
+	// ctx aborts the request when done. The conn is closed to unblock io in progress.
+	ctx context.Context
 }
 
 func (r *request) do() ([]byte, *Error) {
+	defer r.header.release()
+	if r.ctx != nil {
+		stop := r.watchContext()
+		defer stop()
+	}
+
+	if r.body != nil && transferStep(r.bodyProgress, r.bodyLimiters) == 0 {
+		if tcp, ok := r.c.Conn.(*net.TCPConn); ok && r.ioTimeout > 0 {
+			//send header and body in one writev
+			if err := tcp.SetWriteDeadline(time.Now().Add(r.ioTimeout)); err != nil {
+				return nil, NewError("WriteRequestHeaderErr", err)
+			}
+			if _, err := r.header.buffers(r.body).WriteTo(tcp); err != nil {
+				r.c.MarkUnusable()
+				return nil, NewError("WriteRequestBodyErr", err)
+			}
+			return r.readResponse()
+		}
+	}
+
 	//send header
-	if _, err := r.c.Write(r.header); err != nil {
+	if _, err := r.c.Write(r.header.Bytes()); err != nil {
 		return nil, NewError("WriteRequestHeaderErr", err)
 	}
 	//send body
 	if r.body != nil {
-		if _, err := r.c.Write(r.body); err != nil {
+		if err := r.writeBody(); err != nil {
+			r.c.MarkUnusable()
+			return nil, NewError("WriteRequestBodyErr", err)
+		}
+	} else if r.bodyReader != nil {
+		if err := r.writeBodyFrom(); err != nil {
+			//server is still waiting for the rest of the body, the conn cannot be reused
+			r.c.MarkUnusable()
 			return nil, NewError("WriteRequestBodyErr", err)
 		}
 	}
@@ -28,10 +89,114 @@ func (r *request) do() ([]byte, *Error) {
 	return r.readResponse()
 }
 
+// watchContext close the conn when ctx is done before the returned stop function is called.
+// The conn is marked unusable since the server may still be sending the response.
+// Stop waits for the watcher to exit, so the conn is not touched after the request returns.
+func (r *request) watchContext() func() {
+	stopped := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-r.ctx.Done():
+			r.c.MarkUnusable()
+			r.c.Conn.Close()
+		case <-stopped:
+		}
+	}()
+	return func() {
+		close(stopped)
+		<-exited
+	}
+}
+
+// transferStep return bytes of each step if a body must be transferred step by step,
+// because of progress report or bandwidth throttle. It returns 0 if there is no need.
+func transferStep(p *Progress, ls limiters) int64 {
+	var step int64
+	if p != nil {
+		step = p.step()
+	}
+	if ls.active() && (step == 0 || step > throttleStep) {
+		step = throttleStep
+	}
+	return step
+}
+
+// writeBody write body to the conn, in steps if progress or throttle is set.
+func (r *request) writeBody() error {
+	step := transferStep(r.bodyProgress, r.bodyLimiters)
+	if step == 0 {
+		_, err := r.c.Write(r.body)
+		return err
+	}
+	total := int64(len(r.body))
+	for done := int64(0); done < total; {
+		n := total - done
+		if n > step {
+			n = step
+		}
+		r.bodyLimiters.wait(n)
+		if _, err := r.c.Write(r.body[done : done+n]); err != nil {
+			return err
+		}
+		done += n
+		r.bodyProgress.report(done, total)
+	}
+	return nil
+}
+
+// writeBodyFrom copy bodyLen bytes from bodyReader to the conn, in steps if progress or throttle is set.
+// A file is copied to the underlying tcp conn directly, so io.Copy can use sendfile.
+func (r *request) writeBodyFrom() error {
+	f, isFile := r.bodyReader.(*os.File)
+	tcp, isTCP := r.c.Conn.(*net.TCPConn)
+	sendfile := isFile && isTCP
+	segment := transferStep(r.bodyProgress, r.bodyLimiters)
+	if segment == 0 {
+		if !sendfile {
+			_, err := io.CopyN(r.c, r.bodyReader, r.bodyLen)
+			return err
+		}
+		segment = sendfileSegment
+	}
+
+	for remain := r.bodyLen; remain > 0; {
+		n := remain
+		if n > segment {
+			n = segment
+		}
+		r.bodyLimiters.wait(n)
+		var err error
+		if sendfile {
+			err = r.sendfile(tcp, f, n)
+		} else {
+			_, err = io.CopyN(r.c, r.bodyReader, n)
+		}
+		if err != nil {
+			return err
+		}
+		remain -= n
+		r.bodyProgress.report(r.bodyLen-remain, r.bodyLen)
+	}
+	return nil
+}
+
+// sendfile copy n bytes of f to tcp with a renewed write deadline.
+func (r *request) sendfile(tcp *net.TCPConn, f *os.File, n int64) error {
+	if r.ioTimeout > 0 {
+		if err := tcp.SetWriteDeadline(time.Now().Add(r.ioTimeout)); err != nil {
+			return err
+		}
+	}
+	_, err := io.CopyN(tcp, f, n)
+	return err
+}
+
 func (r *request) readResponse() ([]byte, *Error) {
 	//receive response header
 	h := header{}
-	if err := h.read(r.c); err != nil {
+	if err := h.read(r.c, r.header.scratch[:]); err != nil {
 		return nil, NewError("ReadResponseHeaderErr", err)
 	}
 	if r.respLimit > 0 && h.pkgLen > r.respLimit {
@@ -39,9 +204,37 @@ func (r *request) readResponse() ([]byte, *Error) {
 		return nil, NewError("WrongPkgLengthErr", fmt.Errorf("receive header pkg length %d exceed expected or limit size: %d", h.pkgLen, r.respLimit))
 	}
 	//receive body
-	resp := make([]byte, h.pkgLen)
-	if _, err := io.ReadFull(r.c, resp); err != nil {
+	var resp []byte
+	if r.respBuf != nil {
+		resp = r.respBuf[:h.pkgLen]
+	} else {
+		resp = make([]byte, h.pkgLen)
+	}
+	if err := r.readBody(resp); err != nil {
 		return nil, NewError("ReadResponseBodyErr", err)
 	}
 	return resp, nil
 }
+
+// readBody fill resp from the conn, in steps if progress or throttle is set.
+func (r *request) readBody(resp []byte) error {
+	step := transferStep(r.respProgress, r.respLimiters)
+	if step == 0 {
+		_, err := io.ReadFull(r.c, resp)
+		return err
+	}
+	total := int64(len(resp))
+	for done := int64(0); done < total; {
+		n := total - done
+		if n > step {
+			n = step
+		}
+		r.respLimiters.wait(n)
+		if _, err := io.ReadFull(r.c, resp[done:done+n]); err != nil {
+			return err
+		}
+		done += n
+		r.respProgress.report(done, total)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/giantpoplar/pool"
+)
+
+func TestWatchContextStop(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	r := &request{c: &pool.WrappedConn{Conn: client}}
+	for i := 0; i < 1000; i++ {
+		//cancel right after a successful request, as the winner of a hedged download does
+		ctx, cancel := context.WithCancel(context.Background())
+		r.ctx = ctx
+		stop := r.watchContext()
+		stop()
+		cancel()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	go server.Write([]byte("x"))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1)
+	if _, err := client.Read(b); err != nil {
+		t.Fatalf("conn closed after the request returned: %v", err)
+	}
+}
+
+func TestRequestProgress(t *testing.T) {
+	body := []byte("0123456789")
+	for _, c := range []struct {
+		name string
+		req  request
+	}{
+		{"body", request{body: body}},
+		{"body reader", request{bodyReader: bytes.NewReader(body), bodyLen: int64(len(body))}},
+		{"response", request{}},
+	} {
+		client, server := net.Pipe()
+		var calls []string
+		p := &Progress{Step: 4, Func: func(done, total int64) {
+			calls = append(calls, fmt.Sprintf("%d/%d", done, total))
+		}}
+		r := c.req
+		r.c = &pool.WrappedConn{Conn: client}
+		r.header = uploadHeader(int64(len(body)), 0, "txt", false)
+		if r.body != nil || r.bodyReader != nil {
+			r.bodyProgress = p
+		} else {
+			r.respProgress = p
+		}
+
+		go func() {
+			//read the request and respond the body to download
+			n := headerLen + 15
+			if r.respProgress == nil {
+				n += len(body)
+			}
+			io.ReadFull(server, make([]byte, n))
+			resp := make([]byte, headerLen)
+			binary.BigEndian.PutUint64(resp, uint64(len(body)))
+			resp[8] = STORAGE_PROTO_CMD_RESP
+			server.Write(append(resp, body...))
+		}()
+		resp, err := r.do()
+		if err != nil || !bytes.Equal(resp, body) {
+			t.Errorf("%s: response %q, %v", c.name, resp, err)
+		}
+		if fmt.Sprint(calls) != "[4/10 8/10 10/10]" {
+			t.Errorf("%s: progress %v", c.name, calls)
+		}
+		client.Close()
+		server.Close()
+	}
+}
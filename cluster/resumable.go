@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+)
+
+// defaultChunkSize is bytes sent by one append if chunk size is not set.
+const defaultChunkSize = 4 * 1024 * 1024
+
+// ResumableUploader uploads large content to an appender file chunk by chunk.
+//
+// The first chunk creates the appender file and the others are appended to it. Progress is
+// saved to the checkpoint store after every chunk. If the upload is interrupted, call Upload
+// with the same key again and it continues from the saved offset. Bytes beyond the saved
+// offset were not confirmed and will be truncated before continue.
+//
+// If the process exits after the first chunk is uploaded and before its checkpoint is saved,
+// the appender file is left orphan and the next Upload creates another one. Remove such files
+// by their extension or by time if the upload is critical.
+type ResumableUploader struct {
+	cluster *Cluster
+
+	store CheckpointStore
+
+	chunkSize int64
+
+	// file operations on the cluster, replaced in tests
+	uploadAppender func(b []byte, group, ext string) (string, *Error)
+	appender       func(fid string) (appenderStorage, string, *Error)
+	delete         func(fid string) *Error
+}
+
+// appenderStorage is the storage of an appender file, Storage implements it
+type appenderStorage interface {
+	QueryFileInfo(filename string) (*FileInfo, *Error)
+	Truncate(filename string, size int64) *Error
+	Append(b []byte, filename string) *Error
+}
+
+// NewResumableUploader create a resumable uploader of DefaultCluster.
+//
+// NewResumableUploader is a wrapper of DefaultCluster.NewResumableUploader.
+func NewResumableUploader(store CheckpointStore, chunkSize int64) *ResumableUploader {
+	return DefaultCluster.NewResumableUploader(store, chunkSize)
+}
+
+// NewResumableUploader create a resumable uploader saving progress to store.
+// If chunkSize is not positive, 4M is used.
+func (c *Cluster) NewResumableUploader(store CheckpointStore, chunkSize int64) *ResumableUploader {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	return &ResumableUploader{
+		cluster:   c,
+		store:     store,
+		chunkSize: chunkSize,
+		uploadAppender: func(b []byte, group, ext string) (string, *Error) {
+			return c.upload(b, group, ext, true, nil)
+		},
+		appender: func(fid string) (appenderStorage, string, *Error) {
+			s, filename, err := c.updateStorage(fid)
+			if err != nil {
+				return nil, "", err
+			}
+			return s, filename, nil
+		},
+		delete: c.delete,
+	}
+}
+
+// Upload size bytes of r to the group with specified extension name and return the appender file id.
+// Key identifies the upload in checkpoint store, it must be the same when resuming.
+// Checkpoint is removed after the whole content is uploaded.
+func (u *ResumableUploader) Upload(key string, r io.ReaderAt, size int64, group, ext string) (string, error) {
+	cp, e := u.store.Load(key)
+	if e != nil {
+		return "", u.cluster.wrapError(checkpointErr(e))
+	}
+
+	buf := make([]byte, u.chunkSize)
+	if cp == nil {
+		//upload first chunk to create the appender file
+		b, err := u.readChunk(r, buf, 0, size)
+		if err != nil {
+			return "", err
+		}
+		fid, err := u.uploadAppender(b, group, ext)
+		if err != nil {
+			return "", err
+		}
+		cp = &Checkpoint{Fid: fid, Offset: int64(len(b)), Size: size}
+		if e := u.store.Save(key, cp); e != nil {
+			//the appender file could not be resumed without checkpoint
+			u.delete(fid)
+			return "", u.cluster.wrapError(checkpointErr(e))
+		}
+	} else if cp.Size != size {
+		return "", u.cluster.wrapError(checkpointErr(fmt.Errorf("checkpoint size %d != source size %d", cp.Size, size)))
+	}
+
+	s, filename, err := u.appender(cp.Fid)
+	if err != nil {
+		return "", err
+	}
+	if err := u.recover(s, filename, cp.Offset); err != nil {
+		return "", u.cluster.wrapError(err)
+	}
+
+	for cp.Offset < size {
+		b, err := u.readChunk(r, buf, cp.Offset, size)
+		if err != nil {
+			return "", err
+		}
+		if err := s.Append(b, filename); err != nil {
+			return "", u.cluster.wrapError(err)
+		}
+		cp.Offset += int64(len(b))
+		if e := u.store.Save(key, cp); e != nil {
+			return "", u.cluster.wrapError(checkpointErr(e))
+		}
+	}
+
+	if e := u.store.Remove(key); e != nil {
+		return "", u.cluster.wrapError(checkpointErr(e))
+	}
+	return cp.Fid, nil
+}
+
+// recover make sure the appender file size equals confirmed offset.
+// A torn tail left by an interrupted append is truncated.
+func (u *ResumableUploader) recover(s appenderStorage, filename string, offset int64) *Error {
+	info, err := s.QueryFileInfo(filename)
+	if err != nil {
+		return err
+	}
+	if info.Size < offset {
+		return checkpointErr(fmt.Errorf("file size %d is less than checkpoint offset %d", info.Size, offset))
+	}
+	if info.Size > offset {
+		return s.Truncate(filename, offset)
+	}
+	return nil
+}
+
+// readChunk read next chunk from offset into buf.
+func (u *ResumableUploader) readChunk(r io.ReaderAt, buf []byte, offset, size int64) ([]byte, *Error) {
+	n := size - offset
+	if n > int64(len(buf)) {
+		n = int64(len(buf))
+	}
+	m, e := r.ReadAt(buf[:n], offset)
+	if int64(m) < n {
+		return nil, u.cluster.wrapError(readSourceErr(e))
+	}
+	return buf[:n], nil
+}
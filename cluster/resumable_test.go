@@ -0,0 +1,129 @@
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// memCheckpointStore keeps checkpoints in memory, Save fails if saveErr is set
+type memCheckpointStore struct {
+	cps     map[string]Checkpoint
+	saveErr error
+}
+
+func (ms *memCheckpointStore) Load(key string) (*Checkpoint, error) {
+	cp, ok := ms.cps[key]
+	if !ok {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+func (ms *memCheckpointStore) Save(key string, cp *Checkpoint) error {
+	if ms.saveErr != nil {
+		return ms.saveErr
+	}
+	ms.cps[key] = *cp
+	return nil
+}
+
+func (ms *memCheckpointStore) Remove(key string) error {
+	delete(ms.cps, key)
+	return nil
+}
+
+// appenderFile fakes an appender file on storage
+type appenderFile struct {
+	content   []byte
+	truncated []int64
+	appends   int
+}
+
+func (af *appenderFile) QueryFileInfo(filename string) (*FileInfo, *Error) {
+	return &FileInfo{Size: int64(len(af.content))}, nil
+}
+
+func (af *appenderFile) Truncate(filename string, size int64) *Error {
+	af.truncated = append(af.truncated, size)
+	af.content = af.content[:size]
+	return nil
+}
+
+func (af *appenderFile) Append(b []byte, filename string) *Error {
+	af.appends++
+	af.content = append(af.content, b...)
+	return nil
+}
+
+func newTestResumableUploader(store CheckpointStore, af *appenderFile) (*ResumableUploader, *[]string) {
+	var deleted []string
+	u := New("test").NewResumableUploader(store, 10)
+	u.uploadAppender = func(b []byte, group, ext string) (string, *Error) {
+		af.content = append([]byte(nil), b...)
+		return group + "/M00/00/00/appender." + ext, nil
+	}
+	u.appender = func(fid string) (appenderStorage, string, *Error) {
+		return af, "M00/00/00/appender.tar", nil
+	}
+	u.delete = func(fid string) *Error {
+		deleted = append(deleted, fid)
+		return nil
+	}
+	return u, &deleted
+}
+
+func TestResumableUpload(t *testing.T) {
+	source := []byte("0123456789abcdefghijABCDE")
+	store := &memCheckpointStore{cps: make(map[string]Checkpoint)}
+	af := &appenderFile{}
+	u, _ := newTestResumableUploader(store, af)
+
+	fid, err := u.Upload("backup", bytes.NewReader(source), int64(len(source)), "g1", "tar")
+	if err != nil || fid != "g1/M00/00/00/appender.tar" {
+		t.Fatal(fid, err)
+	}
+	if !bytes.Equal(af.content, source) || af.appends != 2 || len(af.truncated) != 0 {
+		t.Errorf("content %q, %d appends, truncated %v", af.content, af.appends, af.truncated)
+	}
+	if len(store.cps) != 0 {
+		t.Errorf("checkpoint %v is not removed", store.cps)
+	}
+}
+
+func TestResumableUploadResume(t *testing.T) {
+	source := []byte("0123456789abcdefghijABCDE")
+	fid := "g1/M00/00/00/appender.tar"
+	store := &memCheckpointStore{cps: map[string]Checkpoint{"backup": {Fid: fid, Offset: 10, Size: 25}}}
+	//the interrupted append left a torn tail
+	af := &appenderFile{content: []byte("0123456789abc")}
+	u, _ := newTestResumableUploader(store, af)
+
+	got, err := u.Upload("backup", bytes.NewReader(source), int64(len(source)), "g1", "tar")
+	if err != nil || got != fid {
+		t.Fatal(got, err)
+	}
+	if !bytes.Equal(af.content, source) || af.appends != 2 || len(af.truncated) != 1 || af.truncated[0] != 10 {
+		t.Errorf("content %q, %d appends, truncated %v", af.content, af.appends, af.truncated)
+	}
+
+	//the file lost confirmed bytes
+	store.cps["backup"] = Checkpoint{Fid: fid, Offset: 20, Size: 25}
+	af.content = []byte("0123456789")
+	if _, err := u.Upload("backup", bytes.NewReader(source), int64(len(source)), "g1", "tar"); err == nil {
+		t.Error("resume a file shorter than checkpoint")
+	}
+}
+
+func TestResumableUploadSaveFailed(t *testing.T) {
+	source := []byte("0123456789abcdefghijABCDE")
+	store := &memCheckpointStore{cps: make(map[string]Checkpoint), saveErr: errors.New("disk full")}
+	u, deleted := newTestResumableUploader(store, &appenderFile{})
+
+	if _, err := u.Upload("backup", bytes.NewReader(source), int64(len(source)), "g1", "tar"); err == nil {
+		t.Fatal("upload without checkpoint")
+	}
+	if len(*deleted) != 1 || (*deleted)[0] != "g1/M00/00/00/appender.tar" {
+		t.Errorf("deleted %v", *deleted)
+	}
+}
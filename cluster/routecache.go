@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// defaultRouteCacheSize is max cached download routes if size is not set
+const defaultRouteCacheSize = 10000
+
+// uploadRoute is cached upload storage of a group. Storage are used in turn.
+type uploadRoute struct {
+	infos  []*TrackerStoreInfo
+	next   int
+	expire time.Time
+}
+
+// downloadRoute is cached download storage of a file.
+type downloadRoute struct {
+	fid    string
+	info   *TrackerStoreInfo
+	expire time.Time
+}
+
+// routeCache caches tracker query results for a ttl. A ttl not greater than 0 disables it.
+type routeCache struct {
+	ttl  time.Duration
+	size int
+
+	upload map[string]*uploadRoute
+
+	// download maps fid to its element in downloads, the most recently used first
+	download  map[string]*list.Element
+	downloads *list.List
+
+	// now is time source, replaced in tests
+	now func() time.Time
+
+	mtx sync.Mutex
+}
+
+func newRouteCache() *routeCache {
+	return &routeCache{
+		size:      defaultRouteCacheSize,
+		upload:    make(map[string]*uploadRoute),
+		download:  make(map[string]*list.Element),
+		downloads: list.New(),
+		now:       time.Now,
+	}
+}
+
+// update ttl and size if set. Cached routes are dropped.
+func (rc *routeCache) update(ttl time.Duration, size int) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	if ttl != 0 {
+		rc.ttl = ttl
+	}
+	if size > 0 {
+		rc.size = size
+	}
+	rc.upload = make(map[string]*uploadRoute)
+	rc.download = make(map[string]*list.Element)
+	rc.downloads = list.New()
+}
+
+func (rc *routeCache) enabled() bool {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	return rc.ttl > 0
+}
+
+// uploadStorage return next cached upload storage of group
+func (rc *routeCache) uploadStorage(group string) (*TrackerStoreInfo, bool) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	r, ok := rc.upload[group]
+	if !ok || len(r.infos) == 0 {
+		return nil, false
+	}
+	if rc.now().After(r.expire) {
+		delete(rc.upload, group)
+		return nil, false
+	}
+	info := r.infos[r.next%len(r.infos)]
+	r.next++
+	return info, true
+}
+
+// setUploadStorage cache upload storage of group and return the first one
+func (rc *routeCache) setUploadStorage(group string, infos []*TrackerStoreInfo) *TrackerStoreInfo {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	rc.upload[group] = &uploadRoute{infos: infos, next: 1, expire: rc.now().Add(rc.ttl)}
+	return infos[0]
+}
+
+// invalidateUpload remove the failed storage from cached upload storage of group
+func (rc *routeCache) invalidateUpload(group, address string) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	r, ok := rc.upload[group]
+	if !ok {
+		return
+	}
+	infos := make([]*TrackerStoreInfo, 0, len(r.infos))
+	for _, info := range r.infos {
+		if info.Address != address {
+			infos = append(infos, info)
+		}
+	}
+	if len(infos) == 0 {
+		delete(rc.upload, group)
+		return
+	}
+	r.infos = infos
+}
+
+// downloadStorage return cached download storage of fid
+func (rc *routeCache) downloadStorage(fid string) (*TrackerStoreInfo, bool) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	e, ok := rc.download[fid]
+	if !ok {
+		return nil, false
+	}
+	r := e.Value.(*downloadRoute)
+	if rc.now().After(r.expire) {
+		rc.removeDownload(e)
+		return nil, false
+	}
+	rc.downloads.MoveToFront(e)
+	return r.info, true
+}
+
+// setDownloadStorage cache download storage of fid. If cache is full, the least recently
+// used route is removed.
+func (rc *routeCache) setDownloadStorage(fid string, info *TrackerStoreInfo) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	r := &downloadRoute{fid: fid, info: info, expire: rc.now().Add(rc.ttl)}
+	if e, ok := rc.download[fid]; ok {
+		e.Value = r
+		rc.downloads.MoveToFront(e)
+		return
+	}
+	for len(rc.download) >= rc.size {
+		rc.removeDownload(rc.downloads.Back())
+	}
+	rc.download[fid] = rc.downloads.PushFront(r)
+}
+
+// invalidateDownload remove cached download storage of fid
+func (rc *routeCache) invalidateDownload(fid string) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	if e, ok := rc.download[fid]; ok {
+		rc.removeDownload(e)
+	}
+}
+
+// removeDownload remove the route element. Caller must hold mtx.
+func (rc *routeCache) removeDownload(e *list.Element) {
+	rc.downloads.Remove(e)
+	delete(rc.download, e.Value.(*downloadRoute).fid)
+}
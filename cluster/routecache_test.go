@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRouteCacheUpload(t *testing.T) {
+	now := time.Unix(1536665400, 0)
+	rc := newRouteCache()
+	rc.now = func() time.Time { return now }
+	rc.update(time.Minute, 0)
+
+	infos := []*TrackerStoreInfo{
+		{Address: "10.0.0.1:23000", Group: "g1"},
+		{Address: "10.0.0.2:23000", Group: "g1"},
+	}
+	if info := rc.setUploadStorage("g1", infos); info != infos[0] {
+		t.Errorf("set return %v, want first storage", info)
+	}
+	for _, want := range []*TrackerStoreInfo{infos[1], infos[0], infos[1]} {
+		if info, ok := rc.uploadStorage("g1"); !ok || info != want {
+			t.Errorf("rotate return %v, want %v", info, want)
+		}
+	}
+
+	rc.invalidateUpload("g1", "10.0.0.2:23000")
+	if info, ok := rc.uploadStorage("g1"); !ok || info != infos[0] {
+		t.Errorf("after invalidate return %v, want %v", info, infos[0])
+	}
+	rc.invalidateUpload("g1", "10.0.0.1:23000")
+	if _, ok := rc.uploadStorage("g1"); ok {
+		t.Error("all storage invalidated, should miss")
+	}
+
+	rc.setUploadStorage("g1", infos)
+	now = now.Add(2 * time.Minute)
+	if _, ok := rc.uploadStorage("g1"); ok {
+		t.Error("expired route should miss")
+	}
+}
+
+func TestRouteCacheDownload(t *testing.T) {
+	now := time.Unix(1536665400, 0)
+	rc := newRouteCache()
+	rc.now = func() time.Time { return now }
+	rc.update(time.Minute, 2)
+
+	info := &TrackerStoreInfo{Address: "10.0.0.1:23000", Group: "g1"}
+	for i := 0; i < 3; i++ {
+		rc.setDownloadStorage(fmt.Sprintf("g1/M00/00/00/%d.jpg", i), info)
+	}
+	if len(rc.download) != 2 {
+		t.Errorf("cached %d routes, want size limit 2", len(rc.download))
+	}
+	if got, ok := rc.downloadStorage("g1/M00/00/00/2.jpg"); !ok || got != info {
+		t.Errorf("latest route should hit, got %v", got)
+	}
+
+	rc.invalidateDownload("g1/M00/00/00/2.jpg")
+	if _, ok := rc.downloadStorage("g1/M00/00/00/2.jpg"); ok {
+		t.Error("invalidated route should miss")
+	}
+
+	rc.setDownloadStorage("g1/M00/00/00/3.jpg", info)
+	now = now.Add(2 * time.Minute)
+	if _, ok := rc.downloadStorage("g1/M00/00/00/3.jpg"); ok {
+		t.Error("expired route should miss")
+	}
+}
+
+func TestRouteCacheDownloadLRU(t *testing.T) {
+	rc := newRouteCache()
+	rc.update(time.Minute, 2)
+
+	info := &TrackerStoreInfo{Address: "10.0.0.1:23000", Group: "g1"}
+	rc.setDownloadStorage("g1/M00/00/00/0.jpg", info)
+	rc.setDownloadStorage("g1/M00/00/00/1.jpg", info)
+	rc.downloadStorage("g1/M00/00/00/0.jpg")
+	rc.setDownloadStorage("g1/M00/00/00/2.jpg", info)
+
+	for fid, cached := range map[string]bool{
+		"g1/M00/00/00/0.jpg": true,
+		"g1/M00/00/00/1.jpg": false,
+		"g1/M00/00/00/2.jpg": true,
+	} {
+		if _, ok := rc.downloadStorage(fid); ok != cached {
+			t.Errorf("%s cached %v, want %v", fid, ok, cached)
+		}
+	}
+	if len(rc.download) != rc.downloads.Len() {
+		t.Errorf("%d routes in map, %d in list", len(rc.download), rc.downloads.Len())
+	}
+}
@@ -1,8 +1,11 @@
 package cluster
 
 import (
+	"context"
 	"encoding/binary"
+	"io"
 	"path/filepath"
+	"time"
 
 	"fmt"
 	"github.com/giantpoplar/pool"
@@ -22,6 +25,17 @@ type Storage struct {
 	// conntection pool
 	pool pool.Pool
 
+	// connection pool of large transfers, created on first use
+	largePool pool.Pool
+
+	// bandwidth limiters of this storage
+	uploadLimiter   *rateLimiter
+	downloadLimiter *rateLimiter
+
+	// bandwidth limiters shared by the cluster, nil if storage is not created by a cluster
+	clusterUploadLimiter   *rateLimiter
+	clusterDownloadLimiter *rateLimiter
+
 	mtx sync.RWMutex
 }
 
@@ -34,6 +48,8 @@ func NewStorage(address, group string, config StorageConfig) (*Storage, *Error)
 		group:   group,
 		config:  defaultStorageConfig.merge(config),
 	}
+	s.uploadLimiter = newRateLimiter(s.config.UploadRateLimit)
+	s.downloadLimiter = newRateLimiter(s.config.DownloadRateLimit)
 	p, err := pool.NewBlockingPool(address, s.config.PoolConfig, nil)
 	if err != nil {
 		return nil, s.wrapError(createPoolErr(err))
@@ -44,10 +60,10 @@ func NewStorage(address, group string, config StorageConfig) (*Storage, *Error)
 
 // Append bytes to the file
 func (s *Storage) Append(b []byte, filename string) *Error {
-	//get a connetion from pool
-	conn, e := s.pool.Get()
-	if e != nil {
-		return s.wrapError(getConnErr(e))
+	//get a connetion from the pool of transfer size
+	conn, err := s.getConn(int64(len(b)))
+	if err != nil {
+		return err
 	}
 	defer conn.Close()
 
@@ -57,19 +73,21 @@ func (s *Storage) Append(b []byte, filename string) *Error {
 	}
 	buffer := h.buffer()
 	//8 bytes: appender filename length
-	binary.Write(buffer, binary.BigEndian, int64(len(filename)))
+	buffer.WriteInt64(int64(len(filename)))
 	//8 bytes: file size
-	binary.Write(buffer, binary.BigEndian, int64(len(b)))
+	buffer.WriteInt64(int64(len(b)))
 	//appender file name
 	buffer.WriteString(filename)
 
 	req := request{
-		c:         conn,
-		header:    buffer.Bytes(),
-		body:      b,
-		respLimit: 130,
+		c:            conn,
+		header:       buffer,
+		body:         b,
+		ioTimeout:    s.ioTimeout(),
+		respLimit:    130,
+		bodyLimiters: s.uploadLimiters(),
 	}
-	_, err := req.do()
+	_, err = req.do()
 	return s.wrapError(err)
 }
 
@@ -88,11 +106,11 @@ func (s *Storage) Delete(filename string) *Error {
 	}
 	buffer := h.buffer()
 	//16 bit groupName
-	buffer.WriteString(fixString(s.group, FDFS_GROUP_NAME_MAX_LEN))
+	buffer.WriteFixString(s.group, FDFS_GROUP_NAME_MAX_LEN)
 	// fileNameLen bit fileName
 	buffer.WriteString(filename)
 
-	req := request{c: conn, header: buffer.Bytes()}
+	req := request{c: conn, header: buffer}
 	_, err := req.do()
 	return s.wrapError(err)
 }
@@ -113,13 +131,85 @@ func (s *Storage) setDownloadSizeLimit(limit int64) {
 
 // Download length bytes of file from offset
 func (s *Storage) Download(filename string, offset, length int64) ([]byte, *Error) {
-	//get a connetion from pool
-	conn, e := s.pool.Get()
-	if e != nil {
-		return nil, s.wrapError(getConnErr(e))
+	return s.DownloadWithProgress(filename, offset, length, nil)
+}
+
+// DownloadContext download length bytes of file from offset. When ctx is done, the download
+// is aborted and its connection is closed.
+func (s *Storage) DownloadContext(ctx context.Context, filename string, offset, length int64) ([]byte, *Error) {
+	return s.download(ctx, filename, offset, length, nil)
+}
+
+// DownloadWithProgress download length bytes of file from offset and report progress to p.
+func (s *Storage) DownloadWithProgress(filename string, offset, length int64, p *Progress) ([]byte, *Error) {
+	return s.download(context.Background(), filename, offset, length, p)
+}
+
+func (s *Storage) download(ctx context.Context, filename string, offset, length int64, p *Progress) ([]byte, *Error) {
+	if ctx.Err() != nil {
+		return nil, s.wrapError(contextErr(ctx.Err()))
+	}
+	size, err := s.downloadSize(filename, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	//get a connetion from the pool of transfer size
+	conn, err := s.getConn(size)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := request{
+		c:            conn,
+		header:       s.downloadHeader(filename, offset, length),
+		respLimit:    s.downloadSizeLimit(),
+		respProgress: p,
+		respLimiters: s.downloadLimiters(),
+	}
+	if ctx.Done() != nil {
+		req.ctx = ctx
+	}
+	recv, err := req.do()
+	if err != nil && ctx.Err() != nil {
+		//io error caused by closing the conn
+		err = contextErr(ctx.Err())
+	}
+	return recv, s.wrapError(err)
+}
+
+// DownloadInto download len(dst) bytes of file from offset into dst and return bytes received.
+// It fails if len(dst) exceeds download size limit. If storage is going to send more bytes than
+// len(dst), it fails before reading the body and the connection is closed.
+func (s *Storage) DownloadInto(filename string, dst []byte, offset int64) (int, *Error) {
+	if len(dst) == 0 {
+		// download bytes 0 means to the end of file
+		return 0, nil
+	}
+	limit := int64(len(dst))
+	if l := s.downloadSizeLimit(); l > 0 && l < limit {
+		return 0, s.wrapError(sizeLimitErr(limit, l))
+	}
+	//get a connetion from the pool of transfer size
+	conn, err := s.getConn(limit)
+	if err != nil {
+		return 0, err
 	}
 	defer conn.Close()
 
+	req := request{
+		c:            conn,
+		header:       s.downloadHeader(filename, offset, limit),
+		respLimit:    limit,
+		respBuf:      dst,
+		respLimiters: s.downloadLimiters(),
+	}
+	recv, err := req.do()
+	return len(recv), s.wrapError(err)
+}
+
+// downloadHeader return download request header
+func (s *Storage) downloadHeader(filename string, offset, length int64) *reqBuffer {
 	h := &header{
 		pkgLen: int64(32 + len(filename)),
 		cmd:    STORAGE_PROTO_CMD_DOWNLOAD_FILE,
@@ -127,21 +217,278 @@ func (s *Storage) Download(filename string, offset, length int64) ([]byte, *Erro
 	buffer := h.buffer()
 	// Request: file_offset(8)  download_bytes(8)  group_name(16)  file_name(n)
 	// offset
-	binary.Write(buffer, binary.BigEndian, offset)
+	buffer.WriteInt64(offset)
 	// download bytes
-	binary.Write(buffer, binary.BigEndian, length)
+	buffer.WriteInt64(length)
 	// 16 bit groupName
-	buffer.WriteString(fixString(s.group, FDFS_GROUP_NAME_MAX_LEN))
+	buffer.WriteFixString(s.group, FDFS_GROUP_NAME_MAX_LEN)
+	// fileName
+	buffer.WriteString(filename)
+	return buffer
+}
+
+// Modify overwrite the appender file with b from offset. Offset must not exceed file size.
+func (s *Storage) Modify(b []byte, filename string, offset int64) *Error {
+	//get a connetion from the pool of transfer size
+	conn, err := s.getConn(int64(len(b)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	h := &header{
+		pkgLen: int64(24 + len(filename) + len(b)),
+		cmd:    STORAGE_PROTO_CMD_MODIFY_FILE,
+	}
+	buffer := h.buffer()
+	//8 bytes: appender filename length
+	buffer.WriteInt64(int64(len(filename)))
+	//8 bytes: file offset
+	buffer.WriteInt64(offset)
+	//8 bytes: modify size
+	buffer.WriteInt64(int64(len(b)))
+	//appender file name
+	buffer.WriteString(filename)
+
+	req := request{
+		c:            conn,
+		header:       buffer,
+		body:         b,
+		ioTimeout:    s.ioTimeout(),
+		bodyLimiters: s.uploadLimiters(),
+	}
+	_, err = req.do()
+	return s.wrapError(err)
+}
+
+// FileInfo is storage return file info of file info query.
+type FileInfo struct {
+	// Size is file size in bytes
+	Size int64
+
+	// CreateTime is file create time on source storage
+	CreateTime time.Time
+
+	// Crc32 is checksum of the whole file content
+	Crc32 uint32
+
+	// SourceIP is ip address of the storage file uploaded to
+	SourceIP string
+}
+
+// cast receive bytes to FileInfo
+func (fi *FileInfo) cast(recv []byte) *Error {
+	// #recv_fmt |-file_size(8)-create_timestamp(8)-crc32(8)-source_ip_addr(16)|
+	if len(recv) != STORAGE_QUERY_FILE_INFO_BODY_LEN {
+		return unexpectedPkgLenErr(len(recv), STORAGE_QUERY_FILE_INFO_BODY_LEN)
+	}
+	fi.Size = int64(binary.BigEndian.Uint64(recv[0:8]))
+	fi.CreateTime = time.Unix(int64(binary.BigEndian.Uint64(recv[8:16])), 0)
+	fi.Crc32 = uint32(binary.BigEndian.Uint64(recv[16:24]))
+	fi.SourceIP = stripString(string(recv[24:40]))
+	return nil
+}
+
+// QueryFileInfo query file size, create time, crc32 and source ip of the file
+func (s *Storage) QueryFileInfo(filename string) (*FileInfo, *Error) {
+	//get a connetion from pool
+	conn, e := s.pool.Get()
+	if e != nil {
+		return nil, s.wrapError(getConnErr(e))
+	}
+	defer conn.Close()
+
+	h := &header{
+		pkgLen: int64(FDFS_GROUP_NAME_MAX_LEN + len(filename)),
+		cmd:    STORAGE_PROTO_CMD_QUERY_FILE_INFO,
+	}
+	buffer := h.buffer()
+	//16 bit groupName
+	buffer.WriteFixString(s.group, FDFS_GROUP_NAME_MAX_LEN)
 	// fileName
 	buffer.WriteString(filename)
 
 	req := request{
 		c:         conn,
-		header:    buffer.Bytes(),
-		respLimit: s.downloadSizeLimit(),
+		header:    buffer,
+		respLimit: STORAGE_QUERY_FILE_INFO_BODY_LEN,
 	}
 	recv, err := req.do()
-	return recv, s.wrapError(err)
+	if err != nil {
+		return nil, s.wrapError(err)
+	}
+	info := &FileInfo{}
+	if err := info.cast(recv); err != nil {
+		return nil, s.wrapError(err)
+	}
+	return info, nil
+}
+
+// Truncate the appender file to size bytes
+func (s *Storage) Truncate(filename string, size int64) *Error {
+	//get a connetion from pool
+	conn, e := s.pool.Get()
+	if e != nil {
+		return s.wrapError(getConnErr(e))
+	}
+	defer conn.Close()
+
+	h := &header{
+		pkgLen: int64(16 + len(filename)),
+		cmd:    STORAGE_PROTO_CMD_TRUNCATE_FILE,
+	}
+	buffer := h.buffer()
+	//8 bytes: appender filename length
+	buffer.WriteInt64(int64(len(filename)))
+	//8 bytes: truncated file size
+	buffer.WriteInt64(size)
+	//appender file name
+	buffer.WriteString(filename)
+
+	req := request{c: conn, header: buffer}
+	_, err := req.do()
+	return s.wrapError(err)
+}
+
+// SetMetadata set metadata of the file. Flag is STORAGE_SET_METADATA_FLAG_OVERWRITE to replace
+// all old metadata, or STORAGE_SET_METADATA_FLAG_MERGE to insert or update the given items.
+func (s *Storage) SetMetadata(filename string, meta map[string]string, flag byte) *Error {
+	//get a connetion from pool
+	conn, e := s.pool.Get()
+	if e != nil {
+		return s.wrapError(getConnErr(e))
+	}
+	defer conn.Close()
+
+	b := encodeMetadata(meta)
+	h := &header{
+		pkgLen: int64(17 + FDFS_GROUP_NAME_MAX_LEN + len(filename) + len(b)),
+		cmd:    STORAGE_PROTO_CMD_SET_METADATA,
+	}
+	buffer := h.buffer()
+	//8 bytes: filename length
+	buffer.WriteInt64(int64(len(filename)))
+	//8 bytes: metadata length
+	buffer.WriteInt64(int64(len(b)))
+	//1 byte: operation flag
+	buffer.WriteByte(flag)
+	//16 bit groupName
+	buffer.WriteFixString(s.group, FDFS_GROUP_NAME_MAX_LEN)
+	//fileName
+	buffer.WriteString(filename)
+
+	req := request{c: conn, header: buffer, body: b}
+	_, err := req.do()
+	return s.wrapError(err)
+}
+
+// GetMetadata return metadata of the file. It is empty if no metadata is set.
+func (s *Storage) GetMetadata(filename string) (map[string]string, *Error) {
+	//get a connetion from pool
+	conn, e := s.pool.Get()
+	if e != nil {
+		return nil, s.wrapError(getConnErr(e))
+	}
+	defer conn.Close()
+
+	h := &header{
+		pkgLen: int64(FDFS_GROUP_NAME_MAX_LEN + len(filename)),
+		cmd:    STORAGE_PROTO_CMD_GET_METADATA,
+	}
+	buffer := h.buffer()
+	//16 bit groupName
+	buffer.WriteFixString(s.group, FDFS_GROUP_NAME_MAX_LEN)
+	//fileName
+	buffer.WriteString(filename)
+
+	req := request{c: conn, header: buffer, respLimit: metadataSizeLimit}
+	recv, err := req.do()
+	if err != nil {
+		return nil, s.wrapError(err)
+	}
+	return decodeMetadata(recv), nil
+}
+
+func (s *Storage) ioTimeout() time.Duration {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.config.PoolConfig.IOTimeout
+}
+
+// setPoolConfig merge pool config and large transfer config, return the large pool config.
+func (s *Storage) setPoolConfig(config StorageConfig) pool.Config {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.config.PoolConfig, _ = s.config.PoolConfig.Merge(config.PoolConfig)
+	s.config.LargePoolConfig, _ = s.config.LargePoolConfig.Merge(config.LargePoolConfig)
+	if config.LargeThreshold != 0 {
+		s.config.LargeThreshold = config.LargeThreshold
+	}
+	return s.largePoolConfig()
+}
+
+// largePoolConfig return PoolConfig overridden by LargePoolConfig. Caller must hold mtx.
+func (s *Storage) largePoolConfig() pool.Config {
+	c, _ := s.config.PoolConfig.Merge(s.config.LargePoolConfig)
+	return c
+}
+
+// getConn get a connection from the pool for transfer of size bytes
+func (s *Storage) getConn(size int64) (*pool.WrappedConn, *Error) {
+	p, err := s.connPool(size)
+	if err != nil {
+		return nil, err
+	}
+	conn, e := p.Get()
+	if e != nil {
+		return nil, s.wrapError(getConnErr(e))
+	}
+	return conn, nil
+}
+
+// connPool return the large pool if size reaches large threshold, otherwise the default pool.
+func (s *Storage) connPool(size int64) (pool.Pool, *Error) {
+	s.mtx.RLock()
+	threshold, lp := s.config.LargeThreshold, s.largePool
+	s.mtx.RUnlock()
+	if threshold <= 0 || size < threshold {
+		return s.pool, nil
+	}
+	if lp != nil {
+		return lp, nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.largePool == nil {
+		p, err := pool.NewBlockingPool(s.address, s.largePoolConfig(), nil)
+		if err != nil {
+			return nil, s.wrapError(createPoolErr(err))
+		}
+		s.largePool = p
+	}
+	return s.largePool, nil
+}
+
+// downloadSize return bytes going to be downloaded. If length is 0 and large pool is enabled,
+// file info is queried to find out the size. Otherwise 0 is returned for unknown.
+func (s *Storage) downloadSize(filename string, offset, length int64) (int64, *Error) {
+	if length > 0 {
+		return length, nil
+	}
+	s.mtx.RLock()
+	threshold := s.config.LargeThreshold
+	s.mtx.RUnlock()
+	if threshold <= 0 {
+		return 0, nil
+	}
+	info, err := s.QueryFileInfo(filename)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size - offset, nil
 }
 
 // Update storage config with new one
@@ -149,49 +496,132 @@ func (s *Storage) Update(config StorageConfig) {
 	if config.DownloadSizeLimit > 0 {
 		s.setDownloadSizeLimit(config.DownloadSizeLimit)
 	}
+	if config.UploadRateLimit != 0 {
+		s.uploadLimiter.setRate(config.UploadRateLimit)
+	}
+	if config.DownloadRateLimit != 0 {
+		s.downloadLimiter.setRate(config.DownloadRateLimit)
+	}
+	large := s.setPoolConfig(config)
 	s.pool.Update(config.PoolConfig)
+	s.mtx.Lock()
+	lp, disabled := s.largePool, s.config.LargeThreshold <= 0
+	if disabled {
+		//all transfers share the default pool again
+		s.largePool = nil
+	}
+	s.mtx.Unlock()
+	if lp == nil {
+		return
+	}
+	if disabled {
+		lp.Close()
+	} else {
+		lp.Update(large)
+	}
+}
+
+// uploadLimiters return limiters of bytes sent to the storage
+func (s *Storage) uploadLimiters() limiters {
+	return limiters{s.uploadLimiter, s.clusterUploadLimiter}
+}
+
+// downloadLimiters return limiters of bytes received from the storage
+func (s *Storage) downloadLimiters() limiters {
+	return limiters{s.downloadLimiter, s.clusterDownloadLimiter}
 }
 
 // Upload a file to the storage path.
 func (s *Storage) Upload(b []byte, pathIndex byte, ext string, allowAppend bool) (string, *Error) {
-	//get a connetion from pool
-	conn, e := s.pool.Get()
-	if e != nil {
-		return "", s.wrapError(getConnErr(e))
+	return s.UploadWithProgress(b, pathIndex, ext, allowAppend, nil)
+}
+
+// UploadWithProgress upload a file to the storage path and report progress to p.
+func (s *Storage) UploadWithProgress(b []byte, pathIndex byte, ext string, allowAppend bool, p *Progress) (string, *Error) {
+	//get a connetion from the pool of transfer size
+	conn, err := s.getConn(int64(len(b)))
+	if err != nil {
+		return "", err
 	}
 	defer conn.Close()
 
+	req := request{
+		c:            conn,
+		header:       uploadHeader(int64(len(b)), pathIndex, ext, allowAppend),
+		body:         b,
+		ioTimeout:    s.ioTimeout(),
+		respLimit:    130,
+		bodyProgress: p,
+		bodyLimiters: s.uploadLimiters(),
+	}
+	recv, err := req.do()
+	if err != nil {
+		return "", s.wrapError(err)
+	}
+	return s.parseFid(recv)
+}
+
+// UploadReader upload size bytes read from r to the storage path.
+//
+// If r is an *os.File and the connection is a TCP connection, the body is sent by
+// sendfile without copying through user space buffers.
+func (s *Storage) UploadReader(r io.Reader, size int64, pathIndex byte, ext string, allowAppend bool) (string, *Error) {
+	return s.UploadReaderWithProgress(r, size, pathIndex, ext, allowAppend, nil)
+}
+
+// UploadReaderWithProgress upload size bytes read from r to the storage path and report progress to p.
+func (s *Storage) UploadReaderWithProgress(r io.Reader, size int64, pathIndex byte, ext string, allowAppend bool, p *Progress) (string, *Error) {
+	//get a connetion from the pool of transfer size
+	conn, err := s.getConn(size)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	req := request{
+		c:            conn,
+		header:       uploadHeader(size, pathIndex, ext, allowAppend),
+		bodyReader:   r,
+		bodyLen:      size,
+		ioTimeout:    s.ioTimeout(),
+		respLimit:    130,
+		bodyProgress: p,
+		bodyLimiters: s.uploadLimiters(),
+	}
+	recv, err := req.do()
+	if err != nil {
+		return "", s.wrapError(err)
+	}
+	return s.parseFid(recv)
+}
+
+// uploadHeader return upload request header with file size
+func uploadHeader(size int64, pathIndex byte, ext string, allowAppend bool) *reqBuffer {
 	cmd := STORAGE_PROTO_CMD_UPLOAD_FILE
 	if allowAppend {
 		cmd = STORAGE_PROTO_CMD_UPLOAD_APPENDER_FILE
 	}
 
 	h := &header{
-		pkgLen: int64(15 + len(b)),
+		pkgLen: 15 + size,
 		cmd:    byte(cmd),
 	}
 	buffer := h.buffer()
 	//store_path_index
 	buffer.WriteByte(pathIndex)
 	// file size
-	binary.Write(buffer, binary.BigEndian, int64(len(b)))
+	buffer.WriteInt64(size)
 	// 6 bit fileExtName
-	buffer.WriteString(fixString(ext, FDFS_FILE_EXT_NAME_MAX_LEN))
-
-	req := request{c: conn, header: buffer.Bytes(), body: b, respLimit: 130}
-	recv, err := req.do()
-	if err != nil {
-		return "", s.wrapError(err)
-	}
-	return s.parseFid(recv)
+	buffer.WriteFixString(ext, FDFS_FILE_EXT_NAME_MAX_LEN)
+	return buffer
 }
 
 // Upload a slave file. Slave file id is {master}{suffix}.{ext}
 func (s *Storage) UploadSlave(b []byte, master, suffix, ext string) (string, *Error) {
-	//get a connetion from pool
-	conn, e := s.pool.Get()
-	if e != nil {
-		return "", s.wrapError(getConnErr(e))
+	//get a connetion from the pool of transfer size
+	conn, err := s.getConn(int64(len(b)))
+	if err != nil {
+		return "", err
 	}
 	defer conn.Close()
 
@@ -203,21 +633,23 @@ func (s *Storage) UploadSlave(b []byte, master, suffix, ext string) (string, *Er
 	buffer := h.buffer()
 
 	// master file name len
-	binary.Write(buffer, binary.BigEndian, int64(len(master)))
+	buffer.WriteInt64(int64(len(master)))
 	// file size
-	binary.Write(buffer, binary.BigEndian, int64(len(b)))
+	buffer.WriteInt64(int64(len(b)))
 	// 16 bit prefixName
-	buffer.WriteString(fixString(suffix, FDFS_FILE_PREFIX_MAX_LEN))
+	buffer.WriteFixString(suffix, FDFS_FILE_PREFIX_MAX_LEN)
 	// 6 bit fileExtName
-	buffer.WriteString(fixString(ext, FDFS_FILE_EXT_NAME_MAX_LEN))
+	buffer.WriteFixString(ext, FDFS_FILE_EXT_NAME_MAX_LEN)
 	// master_file_name
 	buffer.WriteString(master)
 
 	req := request{
-		c:         conn,
-		header:    buffer.Bytes(),
-		body:      b,
-		respLimit: 130,
+		c:            conn,
+		header:       buffer,
+		body:         b,
+		ioTimeout:    s.ioTimeout(),
+		respLimit:    130,
+		bodyLimiters: s.uploadLimiters(),
 	}
 	recv, err := req.do()
 	if err != nil {
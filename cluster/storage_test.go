@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadIntoSizeLimit(t *testing.T) {
+	s := &Storage{address: "127.0.0.1:23000", group: "g1", config: StorageConfig{DownloadSizeLimit: 16}}
+	if n, err := s.DownloadInto("M00/00/00/CgIG6VuXIoeAbiwbAAAIIRe5FG4412.txt", make([]byte, 32), 0); err == nil {
+		t.Errorf("download into buffer over size limit should fail, got %d bytes", n)
+	}
+}
+
+// serveUpload accept one request of reqLen bytes, send the bytes received to got and respond fid
+// g1/M00/00/00/uploaded.txt if all are received.
+func serveUpload(ln net.Listener, reqLen int, got chan<- []byte) {
+	conn, err := ln.Accept()
+	if err != nil {
+		got <- nil
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	b := make([]byte, reqLen)
+	n, err := io.ReadFull(conn, b)
+	got <- b[:n]
+	if err != nil {
+		return
+	}
+	body := make([]byte, FDFS_GROUP_NAME_MAX_LEN)
+	copy(body, "g1")
+	body = append(body, "M00/00/00/uploaded.txt"...)
+	resp := make([]byte, headerLen)
+	binary.BigEndian.PutUint64(resp, uint64(len(body)))
+	resp[8] = STORAGE_PROTO_CMD_RESP
+	conn.Write(append(resp, body...))
+}
+
+func TestUploadReaderWire(t *testing.T) {
+	content := []byte("uploaded by reader")
+	f, err := ioutil.TempFile("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	f.Write(content)
+
+	for _, c := range []struct {
+		name        string
+		r           func() io.Reader
+		allowAppend bool
+	}{
+		{"reader", func() io.Reader { return bytes.NewReader(content) }, false},
+		{"file", func() io.Reader { f.Seek(0, io.SeekStart); return f }, true},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(chan []byte, 1)
+		go serveUpload(ln, headerLen+15+len(content), got)
+
+		s, e := NewStorage(ln.Addr().String(), "g1", StorageConfig{})
+		if e != nil {
+			t.Fatal(e)
+		}
+		fid, e := s.UploadReader(c.r(), int64(len(content)), 1, "txt", c.allowAppend)
+		if e != nil || fid != "g1/M00/00/00/uploaded.txt" {
+			t.Errorf("%s: upload %s, %v", c.name, fid, e)
+		}
+
+		//header, store path index, size, extension and content
+		expect := make([]byte, headerLen+15)
+		binary.BigEndian.PutUint64(expect, uint64(15+len(content)))
+		expect[8] = STORAGE_PROTO_CMD_UPLOAD_FILE
+		if c.allowAppend {
+			expect[8] = STORAGE_PROTO_CMD_UPLOAD_APPENDER_FILE
+		}
+		expect[headerLen] = 1
+		binary.BigEndian.PutUint64(expect[headerLen+1:], uint64(len(content)))
+		copy(expect[headerLen+9:], "txt")
+		expect = append(expect, content...)
+		if b := <-got; !bytes.Equal(b, expect) {
+			t.Errorf("%s: sent % x, expect % x", c.name, b, expect)
+		}
+		ln.Close()
+	}
+}
+
+func TestUploadReaderShort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan []byte, 1)
+	go serveUpload(ln, headerLen+15+100, got)
+
+	s, e := NewStorage(ln.Addr().String(), "g1", StorageConfig{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, e = s.UploadReader(bytes.NewReader(make([]byte, 60)), 100, 0, "txt", false)
+	if e == nil || !strings.HasSuffix(e.name, "WriteRequestBodyErr") {
+		t.Errorf("upload a reader shorter than size: %v", e)
+	}
+	if b := <-got; len(b) != headerLen+15+60 {
+		t.Errorf("sent %d bytes", len(b))
+	}
+}
+
+func TestLargePool(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s, e := NewStorage(ln.Addr().String(), "g1", StorageConfig{LargeThreshold: 100})
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, size := range []int64{0, 1, 99} {
+		if p, _ := s.connPool(size); p != s.pool {
+			t.Errorf("transfer of %d bytes should use the default pool", size)
+		}
+	}
+	large, _ := s.connPool(100)
+	if large == s.pool || large != s.largePool {
+		t.Error("transfer of 100 bytes should use the large pool")
+	}
+	if p, _ := s.connPool(1000); p != large {
+		t.Error("large transfers should share the large pool")
+	}
+
+	s.Update(StorageConfig{LargeThreshold: 200})
+	if p, _ := s.connPool(100); p != s.pool {
+		t.Error("transfer of 100 bytes should use the default pool after update")
+	}
+	if p, _ := s.connPool(200); p != large {
+		t.Error("large pool should be kept after update")
+	}
+
+	s.Update(StorageConfig{LargeThreshold: -1})
+	if s.largePool != nil {
+		t.Error("large pool should be closed after disabled")
+	}
+	if p, _ := s.connPool(1000); p != s.pool {
+		t.Error("all transfers should use the default pool after disabled")
+	}
+}
@@ -13,8 +13,24 @@ type StorageConfig struct {
 	// FastDFS is not designed for it.
 	DownloadSizeLimit int64
 
+	// UploadRateLimit and DownloadRateLimit limit bytes per second of file body sent to and received
+	// from each storage. 0 means not set and no limit. When updating, set a negative value to remove the limit.
+	UploadRateLimit   int64
+	DownloadRateLimit int64
+
 	// Storage connection pool config
 	PoolConfig pool.Config
+
+	// LargeThreshold is bytes from which a transfer is large. Large transfers use a separate pool
+	// configured by LargePoolConfig, so a few large downloads cannot hold all connections used by
+	// small ones. 0 means not set and all transfers share one pool. When updating, set a negative
+	// value to make all transfers share one pool again.
+	//
+	// Download of the whole file needs a file info query to know its size if this is enabled.
+	LargeThreshold int64
+
+	// LargePoolConfig is connection pool config of large transfers. Items not set use PoolConfig.
+	LargePoolConfig pool.Config
 }
 
 var defaultStorageConfig = StorageConfig{
@@ -38,6 +54,16 @@ func (sc *StorageConfig) merge(new StorageConfig) StorageConfig {
 	if new.DownloadSizeLimit > 0 {
 		result.DownloadSizeLimit = new.DownloadSizeLimit
 	}
+	if new.UploadRateLimit != 0 {
+		result.UploadRateLimit = new.UploadRateLimit
+	}
+	if new.DownloadRateLimit != 0 {
+		result.DownloadRateLimit = new.DownloadRateLimit
+	}
+	if new.LargeThreshold != 0 {
+		result.LargeThreshold = new.LargeThreshold
+	}
 	result.PoolConfig, _ = result.PoolConfig.Merge(new.PoolConfig)
+	result.LargePoolConfig, _ = result.LargePoolConfig.Merge(new.LargePoolConfig)
 	return result
 }
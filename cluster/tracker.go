@@ -75,11 +75,11 @@ func (t *Tracker) QueryUploadStorage(group string) (*TrackerStoreInfo, *Error) {
 	}
 	buffer := h.buffer()
 	//16 bit groupName
-	buffer.WriteString(fixString(group, FDFS_GROUP_NAME_MAX_LEN))
+	buffer.WriteFixString(group, FDFS_GROUP_NAME_MAX_LEN)
 
 	r := request{
 		c:      conn,
-		header: buffer.Bytes(),
+		header: buffer,
 	}
 	recv, err := r.do()
 	if err != nil {
@@ -93,6 +93,149 @@ func (t *Tracker) QueryUploadStorage(group string) (*TrackerStoreInfo, *Error) {
 	return info, nil
 }
 
+// QueryUploadStorageAll query all storage of the group available for upload
+func (t *Tracker) QueryUploadStorageAll(group string) ([]*TrackerStoreInfo, *Error) {
+	//get a connection from pool
+	conn, e := t.pool.Get()
+	if e != nil {
+		return nil, t.wrapError(getConnErr(e))
+	}
+	defer conn.Close()
+
+	h := &header{
+		pkgLen: int64(FDFS_GROUP_NAME_MAX_LEN),
+		cmd:    TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITH_GROUP_ALL,
+	}
+	buffer := h.buffer()
+	//16 bit groupName
+	buffer.WriteFixString(group, FDFS_GROUP_NAME_MAX_LEN)
+
+	r := request{
+		c:      conn,
+		header: buffer,
+	}
+	recv, err := r.do()
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := castStoreInfos(recv)
+	if err != nil {
+		return nil, t.wrapError(err)
+	}
+	return infos, nil
+}
+
+// castStoreInfos cast receive bytes of query store all to TrackerStoreInfo list
+func castStoreInfos(recv []byte) ([]*TrackerStoreInfo, *Error) {
+	// #recv_fmt |-group_name(16)-[ip(15)-port(8)]*n-store_path_index(1)|
+	const ipPortLen = IP_ADDRESS_SIZE - 1 + FDFS_PROTO_PKG_LEN_SIZE
+	n := (len(recv) - FDFS_GROUP_NAME_MAX_LEN - 1) / ipPortLen
+	if n <= 0 || len(recv) != FDFS_GROUP_NAME_MAX_LEN+n*ipPortLen+1 {
+		return nil, unexpectedPkgLenErr(len(recv), TRACKER_QUERY_STORAGE_STORE_BODY_LEN)
+	}
+	group := stripString(string(recv[:FDFS_GROUP_NAME_MAX_LEN]))
+	pathIndex := recv[len(recv)-1]
+	infos := make([]*TrackerStoreInfo, n)
+	for i := range infos {
+		b := recv[FDFS_GROUP_NAME_MAX_LEN+i*ipPortLen:]
+		ip := stripString(string(b[:IP_ADDRESS_SIZE-1]))
+		port := binary.BigEndian.Uint64(b[IP_ADDRESS_SIZE-1 : ipPortLen])
+		infos[i] = &TrackerStoreInfo{
+			Address:   fmt.Sprintf("%s:%d", ip, port),
+			Group:     group,
+			PathIndex: pathIndex,
+		}
+	}
+	return infos, nil
+}
+
+// GroupStat is tracker return stat of a storage group.
+type GroupStat struct {
+	// Name of the group
+	Name string
+
+	// TotalMB and FreeMB are disk space of the group in MB
+	TotalMB int64
+	FreeMB  int64
+
+	// TrunkFreeMB is free space in trunk files in MB
+	TrunkFreeMB int64
+
+	// StorageCount is number of storage in the group, ActiveCount is number of active ones
+	StorageCount int64
+	ActiveCount  int64
+
+	// StoragePort and StorageHTTPPort are ports storage of the group listen on
+	StoragePort     int64
+	StorageHTTPPort int64
+
+	// CurrentWriteServer is index of the storage to upload to
+	CurrentWriteServer int64
+
+	// StorePathCount is number of store paths of each storage
+	StorePathCount int64
+
+	// SubdirCountPerPath is number of sub directories of each store path
+	SubdirCountPerPath int64
+
+	// CurrentTrunkFileID is id of trunk file in use
+	CurrentTrunkFileID int64
+}
+
+// cast receive bytes of one group to GroupStat
+func (gs *GroupStat) cast(recv []byte) *Error {
+	// #recv_fmt |-group_name(17)-[field(8)]*11|
+	if len(recv) != TRACKER_GROUP_STAT_LEN {
+		return unexpectedPkgLenErr(len(recv), TRACKER_GROUP_STAT_LEN)
+	}
+	gs.Name = stripString(string(recv[:FDFS_GROUP_NAME_MAX_LEN+1]))
+	fields := []*int64{
+		&gs.TotalMB, &gs.FreeMB, &gs.TrunkFreeMB, &gs.StorageCount, &gs.StoragePort, &gs.StorageHTTPPort,
+		&gs.ActiveCount, &gs.CurrentWriteServer, &gs.StorePathCount, &gs.SubdirCountPerPath, &gs.CurrentTrunkFileID,
+	}
+	b := recv[FDFS_GROUP_NAME_MAX_LEN+1:]
+	for i, f := range fields {
+		*f = int64(binary.BigEndian.Uint64(b[i*8:]))
+	}
+	return nil
+}
+
+// ListGroups query stat of all groups
+func (t *Tracker) ListGroups() ([]*GroupStat, *Error) {
+	//get a connection from pool
+	conn, e := t.pool.Get()
+	if e != nil {
+		return nil, t.wrapError(getConnErr(e))
+	}
+	defer conn.Close()
+
+	h := &header{
+		cmd: TRACKER_PROTO_CMD_SERVER_LIST_ALL_GROUPS,
+	}
+	r := request{
+		c:         conn,
+		header:    h.buffer(),
+		respLimit: FDFS_MAX_GROUPS * TRACKER_GROUP_STAT_LEN,
+	}
+	recv, err := r.do()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recv)%TRACKER_GROUP_STAT_LEN != 0 {
+		return nil, t.wrapError(unexpectedPkgLenErr(len(recv), TRACKER_GROUP_STAT_LEN))
+	}
+	stats := make([]*GroupStat, len(recv)/TRACKER_GROUP_STAT_LEN)
+	for i := range stats {
+		stats[i] = &GroupStat{}
+		if err := stats[i].cast(recv[i*TRACKER_GROUP_STAT_LEN : (i+1)*TRACKER_GROUP_STAT_LEN]); err != nil {
+			return nil, t.wrapError(err)
+		}
+	}
+	return stats, nil
+}
+
 // QueryUpdateStorage query storage info for update actions like delete and append
 func (t *Tracker) QueryUpdateStorage(group, filename string) (*TrackerStoreInfo, *Error) {
 	return t.queryFileStorage(group, filename, TRACKER_PROTO_CMD_SERVICE_QUERY_UPDATE)
@@ -103,8 +246,60 @@ func (t *Tracker) QueryDownloadStorage(group, filename string) (*TrackerStoreInf
 	return t.queryFileStorage(group, filename, TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE)
 }
 
+// QueryDownloadStorageAll query all storage the file can be downloaded from
+func (t *Tracker) QueryDownloadStorageAll(group, filename string) ([]*TrackerStoreInfo, *Error) {
+	recv, err := t.queryFile(group, filename, TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := castFetchInfos(recv)
+	if err != nil {
+		return nil, t.wrapError(err)
+	}
+	return infos, nil
+}
+
+// castFetchInfos cast receive bytes of query fetch all to TrackerStoreInfo list
+func castFetchInfos(recv []byte) ([]*TrackerStoreInfo, *Error) {
+	// #recv_fmt |-group_name(16)-ip(15)-port(8)-[ip(15)]*n|
+	const ipLen = IP_ADDRESS_SIZE - 1
+	if len(recv) < TRACKER_QUERY_STORAGE_FETCH_BODY_LEN || (len(recv)-TRACKER_QUERY_STORAGE_FETCH_BODY_LEN)%ipLen != 0 {
+		return nil, unexpectedPkgLenErr(len(recv), TRACKER_QUERY_STORAGE_FETCH_BODY_LEN)
+	}
+	first := &TrackerStoreInfo{}
+	if err := first.cast(recv[:TRACKER_QUERY_STORAGE_FETCH_BODY_LEN], false); err != nil {
+		return nil, err
+	}
+	//the other storage listen on the same port as the first one
+	port := binary.BigEndian.Uint64(recv[31:39])
+	infos := []*TrackerStoreInfo{first}
+	for b := recv[TRACKER_QUERY_STORAGE_FETCH_BODY_LEN:]; len(b) > 0; b = b[ipLen:] {
+		ip := stripString(string(b[:ipLen]))
+		infos = append(infos, &TrackerStoreInfo{
+			Address: fmt.Sprintf("%s:%d", ip, port),
+			Group:   first.Group,
+		})
+	}
+	return infos, nil
+}
+
 // Query stroage info using filename with specific command
 func (t *Tracker) queryFileStorage(group, filename string, cmd byte) (*TrackerStoreInfo, *Error) {
+	recv, err := t.queryFile(group, filename, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &TrackerStoreInfo{}
+	if err = info.cast(recv, false); err != nil {
+		return nil, t.wrapError(err)
+	}
+	return info, nil
+}
+
+// queryFile send a query of the file with specific command and return the response body
+func (t *Tracker) queryFile(group, filename string, cmd byte) ([]byte, *Error) {
 	//get a connection from pool
 	conn, e := t.pool.Get()
 	if e != nil {
@@ -118,24 +313,15 @@ func (t *Tracker) queryFileStorage(group, filename string, cmd byte) (*TrackerSt
 	}
 	buffer := h.buffer()
 	//16 bit groupName
-	buffer.WriteString(fixString(group, FDFS_GROUP_NAME_MAX_LEN))
+	buffer.WriteFixString(group, FDFS_GROUP_NAME_MAX_LEN)
 	// fileName
 	buffer.WriteString(filename)
 
 	r := request{
 		c:      conn,
-		header: buffer.Bytes(),
-	}
-	recv, err := r.do()
-	if err != nil {
-		return nil, err
+		header: buffer,
 	}
-
-	info := &TrackerStoreInfo{}
-	if err = info.cast(recv, false); err != nil {
-		return nil, t.wrapError(err)
-	}
-	return info, nil
+	return r.do()
 }
 
 // Update tracker pool config
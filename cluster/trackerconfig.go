@@ -7,6 +7,21 @@ import (
 
 // TrackerConfig defines necessary parameters to create a tracker.
 type TrackerConfig struct {
+	// UploadRateLimit and DownloadRateLimit limit bytes per second of file body sent to and received
+	// from all storage of the cluster. They work together with StorageConfig limits of each storage.
+	// 0 means not set and no limit. When updating, set a negative value to remove the limit.
+	UploadRateLimit   int64
+	DownloadRateLimit int64
+
+	// RouteCacheTTL enables the cluster to cache tracker query results: upload storage of each group
+	// and download storage of each file. Upload storage of a group are queried all at once and used
+	// in turn. A route is dropped when the storage returns error. 0 means not set and no cache.
+	// When updating, set a negative value to disable the cache.
+	RouteCacheTTL time.Duration
+
+	// RouteCacheSize is max cached download routes. Default 10000.
+	RouteCacheSize int
+
 	// Tracker connection pool config
 	PoolConfig pool.Config
 }
@@ -26,6 +41,18 @@ var defaultTrackerConfig = TrackerConfig{
 // merge new config to old one.
 func (tc *TrackerConfig) merge(new TrackerConfig) TrackerConfig {
 	result := *tc
+	if new.UploadRateLimit != 0 {
+		result.UploadRateLimit = new.UploadRateLimit
+	}
+	if new.DownloadRateLimit != 0 {
+		result.DownloadRateLimit = new.DownloadRateLimit
+	}
+	if new.RouteCacheTTL != 0 {
+		result.RouteCacheTTL = new.RouteCacheTTL
+	}
+	if new.RouteCacheSize > 0 {
+		result.RouteCacheSize = new.RouteCacheSize
+	}
 	result.PoolConfig, _ = result.PoolConfig.Merge(new.PoolConfig)
 	return result
 }
@@ -0,0 +1,285 @@
+package cluster
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// metaTrashed is metadata name of unix time a file is moved to trash at
+	metaTrashed = "fdfs_trashed"
+
+	// defaultTrashRetention is time files are kept in trash if not set
+	defaultTrashRetention = 7 * 24 * time.Hour
+)
+
+// errRestored is the purge error of a file restored after it is listed to purge
+var errRestored = errors.New("file is restored from trash")
+
+// TrashStore indexes files in trash by time they are purged at. Implementations must be
+// safe for concurrent use. FileExpiryStore is a TrashStore.
+type TrashStore interface {
+	ExpiryStore
+
+	// Contains return true if the file is in trash.
+	Contains(fid string) (bool, error)
+}
+
+// TrashConfig defines trash mode and the purge worker deleting files in trash.
+type TrashConfig struct {
+	// Store is the trash index, nil to disable trash mode.
+	Store TrashStore
+
+	// Retention is time a file is kept in trash before purged. Default 7 days.
+	// Changing it does not affect files already in trash.
+	Retention time.Duration
+
+	// PurgeInterval is time between two purges. Default 1 minute.
+	PurgeInterval time.Duration
+
+	// Rate is max files purged per second. Default 100.
+	Rate int
+
+	// Report is called with the report of each background purge if not nil.
+	Report func(*SweepReport)
+}
+
+// trashBin holds the trash index and the purge worker
+type trashBin struct {
+	store     TrashStore
+	retention time.Duration
+	purger    *sweeper
+
+	// file operations on the cluster, replaced in tests
+	getMetadata func(fid string) (map[string]string, *Error)
+	setMetadata func(fid string, meta map[string]string, flag byte) *Error
+	delete      func(fid string) *Error
+	wrapError   func(err *Error) *Error
+}
+
+// trashLocks serialize Restore and purge of the same file, fids are hashed to a lock
+var trashLocks [64]sync.Mutex
+
+func trashLock(fid string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(fid))
+	return &trashLocks[h.Sum32()%uint32(len(trashLocks))]
+}
+
+// SetTrash enable, reconfigure or disable trash mode.
+//
+// SetTrash is a wrapper of DefaultCluster.SetTrash.
+func SetTrash(config TrashConfig) {
+	DefaultCluster.SetTrash(config)
+}
+
+// SetTrash enable, reconfigure or disable trash mode. Default is disabled.
+//
+// In trash mode, Delete marks the file as trashed in its metadata and the trash index instead of
+// deleting it. Reads of a trashed file fail with an error IsTrashed reports, until it is restored
+// by Restore. A background worker deletes files kept in trash longer than retention.
+//
+// Disabling trash mode stops the purge worker, files in trash are kept until it is enabled again.
+func (c *Cluster) SetTrash(config TrashConfig) {
+	var tb *trashBin
+	if config.Store != nil {
+		tb = c.newTrashBin(config)
+	}
+
+	c.mtx.Lock()
+	old := c.trash
+	c.trash = tb
+	c.mtx.Unlock()
+
+	if old != nil {
+		old.purger.close()
+	}
+	if tb != nil {
+		go tb.purger.run()
+	}
+}
+
+func (c *Cluster) newTrashBin(config TrashConfig) *trashBin {
+	if config.Retention <= 0 {
+		config.Retention = defaultTrashRetention
+	}
+	tb := &trashBin{
+		store:       config.Store,
+		retention:   config.Retention,
+		getMetadata: c.sourceMetadata,
+		setMetadata: c.setMetadata,
+		delete:      c.delete,
+		wrapError:   c.wrapError,
+	}
+	tb.purger = newSweeper(ExpiryConfig{
+		Store:    config.Store,
+		Interval: config.PurgeInterval,
+		Rate:     config.Rate,
+		Report:   config.Report,
+	}, tb.purge)
+	tb.purger.postpone = tb.postpone
+	return tb
+}
+
+func (c *Cluster) trashBin() *trashBin {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.trash
+}
+
+// sourceMetadata return metadata of the file from the storage accepting its updates
+func (c *Cluster) sourceMetadata(fid string) (map[string]string, *Error) {
+	s, filename, err := c.updateStorage(fid)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := s.GetMetadata(filename)
+	if err != nil {
+		return nil, c.wrapError(err)
+	}
+	return meta, nil
+}
+
+// checkTrash return error if the file is in trash
+func (c *Cluster) checkTrash(fid string) *Error {
+	tb := c.trashBin()
+	if tb == nil {
+		return nil
+	}
+	return tb.check(fid)
+}
+
+func (tb *trashBin) check(fid string) *Error {
+	trashed, e := tb.store.Contains(fid)
+	if e != nil {
+		return tb.wrapError(trashErr(e))
+	}
+	if trashed {
+		return tb.wrapError(trashedErr())
+	}
+	return nil
+}
+
+// moveToTrash mark the file as trashed and index it to be purged after retention
+func (c *Cluster) moveToTrash(tb *trashBin, fid string) *Error {
+	if err := tb.trash(fid); err != nil {
+		return err
+	}
+	c.routes.invalidateDownload(fid)
+	c.invalidateCache(fid)
+	return nil
+}
+
+func (tb *trashBin) trash(fid string) *Error {
+	if err := tb.check(fid); err != nil {
+		return err
+	}
+	now := time.Now()
+	meta := map[string]string{metaTrashed: strconv.FormatInt(now.Unix(), 10)}
+	if err := tb.setMetadata(fid, meta, STORAGE_SET_METADATA_FLAG_MERGE); err != nil {
+		return err
+	}
+	if e := tb.store.Add(fid, now.Add(tb.retention)); e != nil {
+		return tb.wrapError(trashErr(e))
+	}
+	return nil
+}
+
+// Restore the file from trash.
+//
+// Restore is a wrapper of DefaultCluster.Restore.
+func Restore(fid string) error {
+	return DefaultCluster.Restore(fid)
+}
+
+// Restore the file from trash. It fails if trash mode is disabled or the file is not in trash.
+// A file restored is never purged, even by a purge in progress.
+func (c *Cluster) Restore(fid string) error {
+	tb := c.trashBin()
+	if tb == nil {
+		return c.wrapError(trashErr(errors.New("trash is disabled")))
+	}
+	if err := tb.restore(fid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (tb *trashBin) restore(fid string) *Error {
+	lock := trashLock(fid)
+	lock.Lock()
+	defer lock.Unlock()
+
+	trashed, e := tb.store.Contains(fid)
+	if e != nil {
+		return tb.wrapError(trashErr(e))
+	}
+	if !trashed {
+		return tb.wrapError(trashErr(errors.New("file is not in trash")))
+	}
+
+	//remove the mark from metadata of the source storage, the file is still trashed if it fails
+	meta, err := tb.getMetadata(fid)
+	if err != nil {
+		return err
+	}
+	if _, ok := meta[metaTrashed]; ok {
+		delete(meta, metaTrashed)
+		if err := tb.setMetadata(fid, meta, STORAGE_SET_METADATA_FLAG_OVERWRITE); err != nil {
+			return err
+		}
+	}
+	if e := tb.store.Remove(fid); e != nil {
+		return tb.wrapError(trashErr(e))
+	}
+	return nil
+}
+
+// purge delete the file if it is still in trash. A file restored after the purge listed it is kept.
+func (tb *trashBin) purge(fid string) *Error {
+	lock := trashLock(fid)
+	lock.Lock()
+	defer lock.Unlock()
+
+	trashed, e := tb.store.Contains(fid)
+	if e != nil {
+		return tb.wrapError(trashErr(e))
+	}
+	if !trashed {
+		return tb.wrapError(trashErr(errRestored))
+	}
+	return tb.delete(fid)
+}
+
+// postpone the purge of the file failed to delete, unless it is restored meanwhile
+func (tb *trashBin) postpone(fid string, expire time.Time) error {
+	lock := trashLock(fid)
+	lock.Lock()
+	defer lock.Unlock()
+
+	trashed, err := tb.store.Contains(fid)
+	if err != nil || !trashed {
+		return err
+	}
+	return tb.store.Add(fid, expire)
+}
+
+// PurgeTrash delete files kept in trash longer than retention now.
+//
+// PurgeTrash is a wrapper of DefaultCluster.PurgeTrash.
+func PurgeTrash() (*SweepReport, error) {
+	return DefaultCluster.PurgeTrash()
+}
+
+// PurgeTrash delete files kept in trash longer than retention now, in addition to the background purges.
+func (c *Cluster) PurgeTrash() (*SweepReport, error) {
+	tb := c.trashBin()
+	if tb == nil {
+		return nil, c.wrapError(trashErr(errors.New("trash is disabled")))
+	}
+	return tb.purger.sweep(), nil
+}
@@ -0,0 +1,140 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// trashFiles fakes metadata and deletion of files on storage
+type trashFiles struct {
+	meta    map[string]map[string]string
+	deleted []string
+}
+
+func (tf *trashFiles) getMetadata(fid string) (map[string]string, *Error) {
+	meta := make(map[string]string)
+	for k, v := range tf.meta[fid] {
+		meta[k] = v
+	}
+	return meta, nil
+}
+
+func (tf *trashFiles) setMetadata(fid string, meta map[string]string, flag byte) *Error {
+	if flag == STORAGE_SET_METADATA_FLAG_OVERWRITE || tf.meta[fid] == nil {
+		tf.meta[fid] = make(map[string]string)
+	}
+	for k, v := range meta {
+		tf.meta[fid][k] = v
+	}
+	return nil
+}
+
+func (tf *trashFiles) delete(fid string) *Error {
+	tf.deleted = append(tf.deleted, fid)
+	return nil
+}
+
+func newTestTrash(t *testing.T, retention time.Duration) (*Cluster, *trashFiles) {
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileExpiryStore(filepath.Join(dir, "trash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf := &trashFiles{meta: make(map[string]map[string]string)}
+	c := New("test")
+	tb := c.newTrashBin(TrashConfig{Store: store, Retention: retention})
+	tb.getMetadata = tf.getMetadata
+	tb.setMetadata = tf.setMetadata
+	tb.delete = tf.delete
+	c.trash = tb
+	return c, tf
+}
+
+func TestTrash(t *testing.T) {
+	c, tf := newTestTrash(t, time.Hour)
+	defer os.RemoveAll(filepath.Dir(c.trash.store.(*FileExpiryStore).path))
+	fid := "group1/M00/00/00/trashed"
+
+	if err := c.Delete(fid); err != nil {
+		t.Fatal(err)
+	}
+	if tf.meta[fid][metaTrashed] == "" || len(tf.deleted) != 0 {
+		t.Fatalf("meta %v deleted %v", tf.meta[fid], tf.deleted)
+	}
+	if err := c.Delete(fid); !IsTrashed(err) {
+		t.Fatalf("delete trashed file: %v", err)
+	}
+	if _, err := c.DownloadFromOffset(fid, 0, 0); !IsTrashed(err) {
+		t.Fatalf("read trashed file: %v", err)
+	}
+
+	//not expired yet
+	report, err := c.PurgeTrash()
+	if err != nil || len(report.Deleted) != 0 || len(tf.deleted) != 0 {
+		t.Fatalf("purge %v %v, deleted %v", report, err, tf.deleted)
+	}
+
+	if err := c.Restore(fid); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tf.meta[fid][metaTrashed]; ok {
+		t.Fatalf("meta %v", tf.meta[fid])
+	}
+	if err := c.checkTrash(fid); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restore(fid); err == nil {
+		t.Fatal("restore file not in trash")
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	c, tf := newTestTrash(t, time.Nanosecond)
+	defer os.RemoveAll(filepath.Dir(c.trash.store.(*FileExpiryStore).path))
+	fids := []string{"group1/M00/00/00/first", "group1/M00/00/00/second"}
+
+	for _, fid := range fids {
+		if err := c.Delete(fid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the other file is restored after the purge listed it
+	var purged, restored string
+	tb := c.trash
+	delete := tb.delete
+	tb.delete = func(fid string) *Error {
+		if purged == "" {
+			purged, restored = fid, fids[0]
+			if fid == fids[0] {
+				restored = fids[1]
+			}
+			if err := c.Restore(restored); err != nil {
+				t.Error(err)
+			}
+		}
+		return delete(fid)
+	}
+
+	report, err := c.PurgeTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0] != purged || len(report.Failed) != 0 {
+		t.Fatalf("deleted %v failed %v", report.Deleted, report.Failed)
+	}
+	if len(tf.deleted) != 1 || tf.deleted[0] != purged {
+		t.Fatalf("deleted %v", tf.deleted)
+	}
+	for _, fid := range fids {
+		if err := c.checkTrash(fid); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// metadata names of files committed by a transaction
+	metaTx          = "fdfs_tx"
+	metaTxCommitted = "fdfs_tx_committed"
+
+	// txJournalExt is extension name of journal files
+	txJournalExt = ".journal"
+
+	// journal record operations
+	txOpBegin  = "begin"
+	txOpUpload = "upload"
+	txOpCommit = "commit"
+)
+
+// txRecord is a line of transaction journal
+type txRecord struct {
+	Op   string `json:"op"`
+	Fid  string `json:"fid,omitempty"`
+	Time int64  `json:"time,omitempty"`
+}
+
+// Tx uploads files staged until Commit. Files are recorded in a journal file before Upload
+// returns, so files of a transaction neither committed nor rolled back are deleted by RecoverTx
+// even if the process crashed. A file uploaded just before a crash but not recorded is left orphan.
+//
+// Methods of Tx are safe for concurrent use.
+type Tx struct {
+	cluster *Cluster
+	id      string
+
+	journal *os.File
+	fids    []string
+	done    bool
+
+	// timer rolls back the transaction on timeout
+	timer *time.Timer
+
+	mtx sync.Mutex
+}
+
+// SetTxJournal set the directory of transaction journals.
+//
+// SetTxJournal is a wrapper of DefaultCluster.SetTxJournal.
+func SetTxJournal(dir string) error {
+	return DefaultCluster.SetTxJournal(dir)
+}
+
+// SetTxJournal set the directory of transaction journals. The directory will be created if not exist.
+// Do not share it between clusters.
+func (c *Cluster) SetTxJournal(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return c.wrapError(txErr(err))
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.txJournalDir = dir
+	return nil
+}
+
+func (c *Cluster) txJournal() (string, *Error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.txJournalDir == "" {
+		return "", c.wrapError(txErr(errors.New("transaction journal is not set")))
+	}
+	return c.txJournalDir, nil
+}
+
+// BeginTx start a transaction.
+//
+// BeginTx is a wrapper of DefaultCluster.BeginTx.
+func BeginTx(timeout time.Duration) (*Tx, error) {
+	return DefaultCluster.BeginTx(timeout)
+}
+
+// BeginTx start a transaction. It is rolled back if not committed within timeout, 0 for no timeout.
+func (c *Cluster) BeginTx(timeout time.Duration) (*Tx, error) {
+	dir, err := c.txJournal()
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, e := rand.Read(id); e != nil {
+		return nil, c.wrapError(txErr(e))
+	}
+	tx := &Tx{cluster: c, id: hex.EncodeToString(id)}
+	f, e := os.OpenFile(filepath.Join(dir, tx.id+txJournalExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if e != nil {
+		return nil, c.wrapError(txErr(e))
+	}
+	tx.journal = f
+	if e := tx.log(txRecord{Op: txOpBegin, Time: time.Now().Unix()}); e != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, c.wrapError(txErr(e))
+	}
+	c.openTxs.Store(tx.id, tx)
+	if timeout > 0 {
+		//the timer may fire before it is assigned, Rollback waits for the lock
+		tx.mtx.Lock()
+		tx.timer = time.AfterFunc(timeout, func() { tx.Rollback() })
+		tx.mtx.Unlock()
+	}
+	return tx, nil
+}
+
+// ID return the transaction id, which is recorded in metadata of committed files.
+func (tx *Tx) ID() string {
+	return tx.id
+}
+
+// log append a record to journal and sync it to disk
+func (tx *Tx) log(r txRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.journal.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return tx.journal.Sync()
+}
+
+// Upload a file to the group with specified extension name in the transaction.
+func (tx *Tx) Upload(b []byte, group, ext string) (string, error) {
+	fid, err := tx.upload(b, group, ext)
+	if err != nil {
+		return "", err
+	}
+	return fid, nil
+}
+
+func (tx *Tx) upload(b []byte, group, ext string) (string, *Error) {
+	c := tx.cluster
+	tx.mtx.Lock()
+	done := tx.done
+	tx.mtx.Unlock()
+	if done {
+		return "", c.wrapError(txErr(errors.New("transaction is finished")))
+	}
+
+	fid, err := c.upload(b, group, ext, false, nil)
+	if err != nil {
+		return "", err
+	}
+
+	tx.mtx.Lock()
+	defer tx.mtx.Unlock()
+
+	if tx.done {
+		//finished while uploading
+		c.delete(fid)
+		return "", c.wrapError(txErr(errors.New("transaction is finished")))
+	}
+	if e := tx.log(txRecord{Op: txOpUpload, Fid: fid}); e != nil {
+		c.delete(fid)
+		return "", c.wrapError(txErr(e))
+	}
+	tx.fids = append(tx.fids, fid)
+	return fid, nil
+}
+
+// Commit mark all files uploaded in the transaction permanent.
+//
+// The commit is recorded in journal first, then files are marked committed in metadata. If marking
+// fails or a file is gone, the error is returned and RecoverTx marks the rest later, files are never
+// deleted after commit.
+func (tx *Tx) Commit() error {
+	c := tx.cluster
+	tx.mtx.Lock()
+	defer tx.mtx.Unlock()
+
+	if tx.done {
+		return c.wrapError(txErr(errors.New("transaction is finished")))
+	}
+	if e := tx.log(txRecord{Op: txOpCommit, Time: time.Now().Unix()}); e != nil {
+		return c.wrapError(txErr(e))
+	}
+	tx.finish()
+	if err := c.markCommitted(tx.id, tx.fids, false); err != nil {
+		return err
+	}
+	if e := os.Remove(tx.journal.Name()); e != nil {
+		return c.wrapError(txErr(e))
+	}
+	return nil
+}
+
+// Rollback delete all files uploaded in the transaction. Files failed to delete are left in
+// journal for RecoverTx.
+func (tx *Tx) Rollback() error {
+	c := tx.cluster
+	tx.mtx.Lock()
+	defer tx.mtx.Unlock()
+
+	if tx.done {
+		return c.wrapError(txErr(errors.New("transaction is finished")))
+	}
+	tx.finish()
+	report := c.rollbackTx(tx.fids)
+	if len(report.Failed) > 0 {
+		return c.wrapError(txErr(fmt.Errorf("%d of %d files failed to delete", len(report.Failed), len(tx.fids))))
+	}
+	if e := os.Remove(tx.journal.Name()); e != nil {
+		return c.wrapError(txErr(e))
+	}
+	return nil
+}
+
+// finish stop the timer and close the journal. The journal left is recovered by RecoverTx from now on.
+func (tx *Tx) finish() {
+	tx.done = true
+	if tx.timer != nil {
+		tx.timer.Stop()
+	}
+	tx.journal.Close()
+	tx.cluster.openTxs.Delete(tx.id)
+}
+
+// markCommitted record the transaction in metadata of files. A file gone is an error unless skipGone,
+// as a recovered transaction may be committed long ago and its files deleted since.
+func (c *Cluster) markCommitted(id string, fids []string, skipGone bool) *Error {
+	meta := map[string]string{metaTx: id, metaTxCommitted: strconv.FormatInt(time.Now().Unix(), 10)}
+	for _, fid := range fids {
+		err := c.setMetadata(fid, meta, STORAGE_SET_METADATA_FLAG_MERGE)
+		if err != nil && !(skipGone && isFileNotExist(err)) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackTx delete files from storage, bypassing trash since they were never committed.
+// A file gone is deleted.
+func (c *Cluster) rollbackTx(fids []string) *SweepReport {
+	report := &SweepReport{Start: time.Now(), Failed: make(map[string]error)}
+	for _, fid := range fids {
+		if err := c.delete(fid); err != nil && !isFileNotExist(err) {
+			report.Failed[fid] = err
+			continue
+		}
+		report.Deleted = append(report.Deleted, fid)
+	}
+	report.End = time.Now()
+	return report
+}
+
+// RecoverTx finish abandoned transactions.
+//
+// RecoverTx is a wrapper of DefaultCluster.RecoverTx.
+func RecoverTx(olderThan time.Duration) (*SweepReport, error) {
+	return DefaultCluster.RecoverTx(olderThan)
+}
+
+// RecoverTx finish transactions whose journal is not written for olderThan, such as those of a
+// crashed process. Files of uncommitted transactions are deleted and reported, committed ones are
+// marked in metadata. Transactions in progress in this process are skipped, olderThan must be longer
+// than those of other processes sharing the directory may be idle.
+//
+// Journals failed to finish are kept and their files are reported as failed.
+func (c *Cluster) RecoverTx(olderThan time.Duration) (*SweepReport, error) {
+	dir, err := c.txJournal()
+	if err != nil {
+		return nil, err
+	}
+	infos, e := ioutil.ReadDir(dir)
+	if e != nil {
+		return nil, c.wrapError(txErr(e))
+	}
+	report := &SweepReport{Start: time.Now(), Failed: make(map[string]error)}
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), txJournalExt) || report.Start.Sub(info.ModTime()) < olderThan {
+			continue
+		}
+		if _, ok := c.openTxs.Load(strings.TrimSuffix(info.Name(), txJournalExt)); ok {
+			continue
+		}
+		if e := c.recoverJournal(filepath.Join(dir, info.Name()), report); e != nil {
+			report.Err = e
+			break
+		}
+	}
+	report.End = time.Now()
+	return report, nil
+}
+
+// recoverJournal roll the transaction forward if committed or back otherwise, then remove its journal
+func (c *Cluster) recoverJournal(path string, report *SweepReport) error {
+	fids, committed, err := readTxJournal(path)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if committed {
+		id := strings.TrimSuffix(filepath.Base(path), txJournalExt)
+		if err := c.markCommitted(id, fids, true); err != nil {
+			for _, fid := range fids {
+				report.Failed[fid] = err
+			}
+			return nil
+		}
+		return os.Remove(path)
+	}
+	rollback := c.rollbackTx(fids)
+	report.Deleted = append(report.Deleted, rollback.Deleted...)
+	for fid, err := range rollback.Failed {
+		report.Failed[fid] = err
+	}
+	if len(rollback.Failed) > 0 {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// readTxJournal return files uploaded and whether the transaction is committed. A torn last
+// line of a crash is ignored.
+func readTxJournal(path string) ([]string, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	var fids []string
+	committed := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r txRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			break
+		}
+		switch r.Op {
+		case txOpUpload:
+			fids = append(fids, r.Fid)
+		case txOpCommit:
+			committed = true
+		}
+	}
+	return fids, committed, scanner.Err()
+}
@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadTxJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a"+txJournalExt)
+	journal := `{"op":"begin","time":1536660000}
+{"op":"upload","fid":"g1/M00/00/00/a.txt"}
+{"op":"upload","fid":"g2/M00/00/00/b.txt"}
+{"op":"upl`
+	if err := ioutil.WriteFile(path, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fids, committed, err := readTxJournal(path)
+	if err != nil || committed || !reflect.DeepEqual(fids, []string{"g1/M00/00/00/a.txt", "g2/M00/00/00/b.txt"}) {
+		t.Fatalf("read torn journal, got %v, %v, %v", fids, committed, err)
+	}
+
+	journal = journal[:len(journal)-len(`{"op":"upl`)] + `{"op":"commit","time":1536660001}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fids, committed, err = readTxJournal(path)
+	if err != nil || !committed || len(fids) != 2 {
+		t.Fatalf("read committed journal, got %v, %v, %v", fids, committed, err)
+	}
+}
+
+func TestRecoverTxSkipOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New("test")
+	if err := c.SetTxJournal(dir); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c.BeginTx(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, tx.ID()+txJournalExt)
+	if _, err := c.RecoverTx(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("journal of open transaction is recovered: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.openTxs.Load(tx.ID()); ok {
+		t.Error("rolled back transaction is still open")
+	}
+}
+
+func TestTxTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New("test")
+	if err := c.SetTxJournal(dir); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := c.BeginTx(time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//empty transactions are rolled back by their timers and leave no journal
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d journals left after timeout", len(infos))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,9 +1,13 @@
 package cluster
 
 import (
+	"sort"
 	"strings"
 )
 
+// metadataSizeLimit is max bytes of metadata received
+const metadataSizeLimit = 64 * 1024
+
 func fileExt(filename string) string {
 	parts := strings.Split(filename, ".")
 	if len(parts) >= 2 {
@@ -34,3 +38,51 @@ func fixString(s string, fix_length int) string {
 	}
 	return string(buff)
 }
+
+// encodeMetadata join metadata items with fdfs separators. Items are sorted by name.
+func encodeMetadata(meta map[string]string) []byte {
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := make([]byte, 0, 64*len(names))
+	for i, name := range names {
+		if i > 0 {
+			b = append(b, FDFS_RECORD_SEPERATOR)
+		}
+		b = append(b, name...)
+		b = append(b, FDFS_FIELD_SEPERATOR)
+		b = append(b, meta[name]...)
+	}
+	return b
+}
+
+// decodeMetadata split metadata items by fdfs separators
+func decodeMetadata(b []byte) map[string]string {
+	meta := make(map[string]string)
+	for _, record := range strings.Split(string(b), string(FDFS_RECORD_SEPERATOR)) {
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, string(FDFS_FIELD_SEPERATOR), 2)
+		if len(fields) == 2 {
+			meta[fields[0]] = fields[1]
+		} else {
+			meta[fields[0]] = ""
+		}
+	}
+	return meta
+}
+
+// duplicateGroup return the first group appearing more than once, or empty if none
+func duplicateGroup(groups []string) string {
+	seen := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		if seen[group] {
+			return group
+		}
+		seen[group] = true
+	}
+	return ""
+}
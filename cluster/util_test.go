@@ -24,3 +24,32 @@ func Test_stripString(t *testing.T) {
 	println(s, len(s))
 
 }
+
+func TestMetadata(t *testing.T) {
+	meta := map[string]string{"width": "1024", "height": "768", "author": ""}
+	b := encodeMetadata(meta)
+	if string(b) != "author\x02\x01height\x02768\x01width\x021024" {
+		t.Fatalf("encode metadata, got %q", b)
+	}
+	got := decodeMetadata(b)
+	if len(got) != len(meta) {
+		t.Fatalf("decode metadata, got %v", got)
+	}
+	for name, value := range meta {
+		if got[name] != value {
+			t.Fatalf("decode metadata, got %v", got)
+		}
+	}
+	if got := decodeMetadata(nil); len(got) != 0 {
+		t.Fatalf("decode empty metadata, got %v", got)
+	}
+}
+
+func TestDuplicateGroup(t *testing.T) {
+	if g := duplicateGroup([]string{"g1", "g2", "g3"}); g != "" {
+		t.Errorf("no duplicate, got %q", g)
+	}
+	if g := duplicateGroup([]string{"g1", "g2", "g2", "g1"}); g != "g2" {
+		t.Errorf("first duplicate, got %q", g)
+	}
+}